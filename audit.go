@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+type TradeAudit struct {
+	Trade        Trade
+	Transactions []Transaction // in the order they were written
+	Hidden       int           // transactions of the trade the caller is not allowed to read
+}
+
+// a trade with all its transactions resolved, filtered by the caller's visibility
+/*			arg 0	:	TradeID
+			arg 1	:	EntityID of the caller
+*/
+func (t *SimpleChaincode) getTradeAudit(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	entity, err := getEntity(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	tradebyte, err := stub.GetState(args[0])
+	if err != nil {
+		return nil, errors.New("Error while getting trade info from ledger")
+	}
+	if len(tradebyte) == 0 {
+		return nil, errors.New("Error trade " + args[0] + " not found")
+	}
+	var trade Trade
+	err = json.Unmarshal(tradebyte, &trade)
+	if err != nil {
+		return nil, errors.New("Error while unmarshalling trade data")
+	}
+
+	audit := TradeAudit{Trade: trade, Transactions: []Transaction{}}
+	for i := 0; i < len(trade.TransactionHistory); i++ {
+		// trades expired or cancelled before closing transactions were recorded hold an empty ID
+		if trade.TransactionHistory[i] == "" {
+			continue
+		}
+		tranbyte, err := stub.GetState(trade.TransactionHistory[i])
+		if err != nil {
+			return nil, errors.New("Error while getting transaction from ledger")
+		}
+		var tran Transaction
+		err = json.Unmarshal(tranbyte, &tran)
+		if err != nil {
+			return nil, errors.New("Error while unmarshalling transaction data")
+		}
+		if !canReadTransaction(entity, tran) {
+			audit.Hidden++
+			continue
+		}
+		// transactions written before the acting identity was recorded
+		if tran.ActorID == "" {
+			switch tran.TransactionType {
+			case "Response":
+				tran.ActorID = tran.BankID
+			case "Request", "Execute", "Exercise":
+				tran.ActorID = tran.ClientID
+			}
+		}
+		audit.Transactions = append(audit.Transactions, tran)
+	}
+	b, err := json.Marshal(audit)
+	if err != nil {
+		return nil, errors.New("Error while marshalling trade audit")
+	}
+	return b, nil
+}
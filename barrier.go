@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const liveBarrierIndex = "liveBarrier" // symbol, tradeID of an executed option whose barrier was not hit yet
+
+// barrier types
+const upAndOut = "UpAndOut"
+const downAndOut = "DownAndOut"
+const upAndIn = "UpAndIn"
+const downAndIn = "DownAndIn"
+
+// barrier states
+const barrierLive = "Live" // not hit yet
+const barrierKnockedIn = "KnockedIn"
+const barrierKnockedOut = "KnockedOut"
+
+// knock-in or knock-out terms of an option, monitored against the published spot
+type Barrier struct {
+	Type     string  // UpAndOut, DownAndOut, UpAndIn or DownAndIn
+	Level    float64 // spot at or beyond which the barrier is hit
+	Rebate   float64 // per share, paid to the holder on knock-out or at expiry of a knock-in never knocked in
+	Status   string  // Live until hit, then KnockedIn or KnockedOut
+	HitAt    time.Time
+	HitPrice float64 // published spot that hit the barrier
+}
+
+func (b Barrier) knockIn() bool {
+	return b.Type == upAndIn || b.Type == downAndIn
+}
+
+func (b Barrier) hit(spot float64) bool {
+	if b.Type == upAndOut || b.Type == upAndIn {
+		return spot >= b.Level
+	}
+	return spot <= b.Level
+}
+
+// true while the holder can exercise, knock-ins once knocked in and knock-outs until knocked out
+func (b Barrier) exercisable() bool {
+	if b.knockIn() {
+		return b.Status == barrierKnockedIn
+	}
+	return b.Status == barrierLive
+}
+
+// rebate per share owed to the holder by a closing transaction of the option
+func barrierRebate(tran Transaction) float64 {
+	if tran.Barrier == nil {
+		return 0
+	}
+	if tran.TransactionType == "KnockOut" {
+		return tran.Barrier.Rebate
+	}
+	if tran.TransactionType == "Expire" && tran.Barrier.knockIn() && tran.Barrier.Status == barrierLive {
+		return tran.Barrier.Rebate
+	}
+	return 0
+}
+
+// barrier terms of a quote request, e.g. {"Type":"UpAndOut","Level":150,"Rebate":1.5}
+func parseBarrier(s string) (*Barrier, error) {
+	var b Barrier
+	err := json.Unmarshal([]byte(s), &b)
+	if err != nil {
+		return nil, errors.New("Error invalid barrier")
+	}
+	valid := false
+	for _, k := range []string{upAndOut, downAndOut, upAndIn, downAndIn} {
+		if strings.EqualFold(b.Type, k) {
+			b.Type = k
+			valid = true
+		}
+	}
+	if !valid {
+		return nil, errors.New("Error invalid barrier type " + b.Type)
+	}
+	if b.Level <= 0 {
+		return nil, errors.New("Error barrier level must be positive")
+	}
+	if b.Rebate < 0 {
+		return nil, errors.New("Error barrier rebate cannot be negative")
+	}
+	return &Barrier{Type: b.Type, Level: b.Level, Rebate: b.Rebate, Status: barrierLive}, nil
+}
+
+// error when the published spot of the symbol already hits the barrier
+func checkBarrierNotHit(stub shim.ChaincodeStubInterface, symbol string, barrier *Barrier) error {
+	if barrier == nil {
+		return nil
+	}
+	m, err := readMarketData(stub, symbol)
+	if err != nil {
+		// nothing published, the first publication decides
+		return nil
+	}
+	if barrier.hit(m.Spot) {
+		return fmt.Errorf("Error barrier %.2f already hit at spot %.2f", barrier.Level, m.Spot)
+	}
+	return nil
+}
+
+// updates or removes the option of a trade held by an entity after its barrier was hit
+func applyBarrierHit(stub shim.ChaincodeStubInterface, entity Entity, tran Transaction) error {
+	var options []Option
+	for i := 0; i < len(entity.Options); i++ {
+		o := entity.Options[i]
+		if o.TradeID == tran.TradeID {
+			if tran.Barrier.Status == barrierKnockedOut {
+				continue
+			}
+			b := *tran.Barrier
+			o.Barrier = &b
+		}
+		options = append(options, o)
+	}
+	entity.Options = options
+	err := recordPositionChanges(stub, entity, tran.TransactionID, tran.TradeID)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(entity)
+	if err != nil {
+		return errors.New("Error while marshalling entity data")
+	}
+	err = stub.PutState(entity.EntityID, b)
+	if err != nil {
+		return errors.New("Error while writing entity to ledger")
+	}
+	return nil
+}
+
+// knocks in or out the trade if the published spot hits its live barrier, ok is false when the barrier
+// was not hit; everything is read before the first write so that a failure leaves the trade as it was
+func hitBarrier(stub shim.ChaincodeStubInterface, tradeID string, m MarketData, transactionID string) (TradeEvent, bool, error) {
+	tradebyte, err := stub.GetState(tradeID)
+	if err != nil {
+		return TradeEvent{}, false, errors.New("Error while getting trade info from ledger")
+	}
+	var trade Trade
+	err = json.Unmarshal(tradebyte, &trade)
+	if err != nil {
+		return TradeEvent{}, false, errors.New("Error while unmarshalling trade data")
+	}
+	if trade.Status != "Trade Executed" {
+		// closed since, the entry is stale
+		return TradeEvent{}, false, delIndexEntry(stub, liveBarrierIndex, symbolIndexValue(m.Symbol), tradeID)
+	}
+	terms, err := currentTerms(stub, trade)
+	if err != nil {
+		return TradeEvent{}, false, err
+	}
+	if terms.Barrier == nil || terms.Barrier.Status != barrierLive {
+		return TradeEvent{}, false, delIndexEntry(stub, liveBarrierIndex, symbolIndexValue(m.Symbol), tradeID)
+	}
+	if !terms.Barrier.hit(m.Spot) {
+		return TradeEvent{}, false, nil
+	}
+	client, err := getEntity(stub, terms.ClientID)
+	if err != nil {
+		return TradeEvent{}, false, err
+	}
+	bank, err := getEntity(stub, terms.BankID)
+	if err != nil {
+		return TradeEvent{}, false, err
+	}
+
+	tran := terms
+	tran.TransactionID = transactionID
+	barrier := *terms.Barrier
+	barrier.HitAt = m.Timestamp
+	barrier.HitPrice = m.Spot
+	status := "Trade Executed"
+	if barrier.knockIn() {
+		tran.TransactionType = "KnockIn"
+		barrier.Status = barrierKnockedIn
+	} else {
+		tran.TransactionType = "KnockOut"
+		barrier.Status = barrierKnockedOut
+		status = "Trade Knocked Out"
+	}
+	tran.Barrier = &barrier
+	tran.SpotPrice = m.Spot
+	tran.Timestamp = m.Timestamp
+	tran.ActorID = m.PublisherID
+	tran.Status = "Success"
+	err = writeTransaction(stub, tran)
+	if err != nil {
+		return TradeEvent{}, false, err
+	}
+	err = applyBarrierHit(stub, client, tran)
+	if err != nil {
+		return TradeEvent{}, false, err
+	}
+	err = applyBarrierHit(stub, bank, tran)
+	if err != nil {
+		return TradeEvent{}, false, err
+	}
+	err = updateTradeState(stub, tran.TradeID, tran.TransactionID, status)
+	if err != nil {
+		return TradeEvent{}, false, err
+	}
+	err = delIndexEntry(stub, liveBarrierIndex, symbolIndexValue(m.Symbol), tradeID)
+	if err != nil {
+		return TradeEvent{}, false, err
+	}
+	return newTradeEvent(tran, status), true, nil
+}
+
+// knocks in or out the executed trades of the symbol whose barrier the published spot hits, a trade that
+// cannot be processed is logged and left for the next publication rather than failing the publication
+func monitorBarriers(stub shim.ChaincodeStubInterface, m MarketData) {
+	tradeIDs, err := tradeIDsFromIndex(stub, 0, liveBarrierIndex, symbolIndexValue(m.Symbol))
+	if err != nil {
+		fmt.Println("barrier monitoring of " + m.Symbol + " failed: " + err.Error())
+		return
+	}
+	if len(tradeIDs) == 0 {
+		return
+	}
+	ctidByte, err := stub.GetState("currentTransactionNum")
+	if err != nil {
+		fmt.Println("barrier monitoring of " + m.Symbol + " failed: Error while getting currentTransactionNum from ledger")
+		return
+	}
+	tid, err := strconv.Atoi(string(ctidByte))
+	if err != nil {
+		fmt.Println("barrier monitoring of " + m.Symbol + " failed: Error while converting ctidByte to integer")
+		return
+	}
+	var events []TradeEvent
+	for i := 0; i < len(tradeIDs); i++ {
+		event, ok, err := hitBarrier(stub, tradeIDs[i], m, "trans"+strconv.Itoa(tid+1))
+		if err != nil {
+			fmt.Println("barrier monitoring of " + tradeIDs[i] + " failed: " + err.Error())
+			continue
+		}
+		if ok {
+			tid++
+			events = append(events, event)
+		}
+	}
+	if len(events) == 0 {
+		return
+	}
+	err = stub.PutState("currentTransactionNum", []byte(strconv.Itoa(tid)))
+	if err != nil {
+		fmt.Println("barrier monitoring of " + m.Symbol + " failed: Error while writing currentTransactionNum to ledger")
+		return
+	}
+	// only one event is delivered per invoke, the first barrier hit carries the others
+	event := events[0]
+	event.Batch = events[1:]
+	err = emitTradeEvent(stub, event)
+	if err != nil {
+		fmt.Println("barrier monitoring of " + m.Symbol + " failed: " + err.Error())
+	}
+}
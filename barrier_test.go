@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestBarrierKnockOut(t *testing.T) {
+	cc := new(SimpleChaincode)
+	stub := newTestLedger(t, time.Date(2026, 3, 2, 15, 0, 0, 0, time.UTC))
+	_, err := cc.publishMarketData(stub, []string{"AAPL", "130", "128", "0.25", entity5})
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	knockOut := executeTestCall(t, stub, "AAPL", "1", "10", "130", `{"Type":"DownAndOut","Level":115,"Rebate":0}`)
+	vanilla := executeTestCall(t, stub, "AAPL", "1", "14.68", "130")
+	if stub.state[indexKey(liveBarrierIndex, "AAPL", knockOut)] == nil {
+		t.Fatalf("executed barrier option is not monitored")
+	}
+	// an index entry that cannot be processed does not fail the publication
+	_ = putIndexEntry(stub, nil, liveBarrierIndex, "AAPL", "trade9999")
+
+	_, err = cc.publishMarketData(stub, []string{"AAPL", "110", "130", "0.25", entity5})
+	if err != nil {
+		t.Fatalf("publish hitting the barrier: %v", err)
+	}
+	if m, _ := readMarketData(stub, "AAPL"); m.Spot != 110 {
+		t.Errorf("spot %.2f was not published", m.Spot)
+	}
+	var trade Trade
+	_ = json.Unmarshal(stub.state[knockOut], &trade)
+	if trade.Status != "Trade Knocked Out" {
+		t.Errorf("trade status %q, want Trade Knocked Out", trade.Status)
+	}
+	if stub.state[indexKey(liveBarrierIndex, "AAPL", knockOut)] != nil {
+		t.Errorf("knocked out option is still monitored")
+	}
+
+	// a knocked out option cannot be exercised and the holders keep their other options
+	exerciseID := nextTransactionID(stub)
+	_, _ = cc.tradeSet(stub, []string{knockOut, "Yes", entity1})
+	if got, want := transactionStatus(stub, exerciseID), "Error trade "+knockOut+" is not open, its status is Trade Knocked Out"; got != want {
+		t.Errorf("exercise after knock-out: got status %q, want %q", got, want)
+	}
+	for _, entityID := range []string{entity1, entity2} {
+		entity, _ := getEntity(stub, entityID)
+		if len(entity.Options) != 1 || entity.Options[0].TradeID != vanilla {
+			t.Errorf("%s holds %+v, want only the option of %s", entityID, entity.Options, vanilla)
+		}
+	}
+}
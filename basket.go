@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const basketTerms = "Basket"
+const maxBasketComponents = 20
+const basketTickSize = 0.01
+
+// weighted basket of registered instruments an option is written on, settled in cash
+type Basket struct {
+	Name       string // chosen by the client, used as the option's StockSymbol
+	Components []BasketComponent
+}
+
+type BasketComponent struct {
+	Symbol string
+	Weight float64 // shares of the symbol in one unit of the basket
+}
+
+func (b Basket) symbols() []string {
+	var symbols []string
+	for i := 0; i < len(b.Components); i++ {
+		symbols = append(symbols, b.Components[i].Symbol)
+	}
+	return symbols
+}
+
+func (b Basket) has(symbol string) bool {
+	for i := 0; i < len(b.Components); i++ {
+		if strings.EqualFold(b.Components[i].Symbol, symbol) {
+			return true
+		}
+	}
+	return false
+}
+
+// part of the contracts and notional of an option on one stock
+type SymbolExposure struct {
+	Symbol    string
+	Contracts int
+	Notional  float64
+}
+
+// contracts and notional of an option on each stock it is written on, all of them on its own stock or, for
+// a basket, contracts times each component's shares per basket unit, rounded up, and notional shared by weight
+func splitExposure(symbol string, basket *Basket, contracts int, notional float64) []SymbolExposure {
+	if basket == nil {
+		return []SymbolExposure{{Symbol: strings.ToUpper(symbol), Contracts: contracts, Notional: notional}}
+	}
+	total := 0.0
+	for i := 0; i < len(basket.Components); i++ {
+		total += basket.Components[i].Weight
+	}
+	parts := make([]SymbolExposure, len(basket.Components))
+	for i := 0; i < len(basket.Components); i++ {
+		c := basket.Components[i]
+		parts[i] = SymbolExposure{
+			Symbol:    c.Symbol,
+			Contracts: int(math.Ceil(float64(contracts)*c.Weight - 1e-9)),
+			Notional:  notional * c.Weight / total,
+		}
+	}
+	return parts
+}
+
+// part of the contracts and notional of an option on a stock, zero when it is not written on it
+func exposureOn(symbol string, basket *Basket, contracts int, notional float64, stock string) (int, float64) {
+	parts := splitExposure(symbol, basket, contracts, notional)
+	for i := 0; i < len(parts); i++ {
+		if strings.EqualFold(parts[i].Symbol, stock) {
+			return parts[i].Contracts, parts[i].Notional
+		}
+	}
+	return 0, 0
+}
+
+// basket terms of a quote request, nil when the terms are not a basket, e.g.
+// {"Type":"Basket","Components":[{"Symbol":"AAPL","Weight":2},{"Symbol":"MSFT","Weight":1}]}
+func parseBasket(stub shim.ChaincodeStubInterface, name string, s string) (*Basket, error) {
+	var in struct {
+		Type       string
+		Components []BasketComponent
+	}
+	err := json.Unmarshal([]byte(s), &in)
+	if err != nil {
+		return nil, errors.New("Error invalid option terms")
+	}
+	if !strings.EqualFold(in.Type, basketTerms) {
+		return nil, nil
+	}
+	basket := Basket{Name: strings.ToUpper(name)}
+	if basket.Name == "" {
+		return nil, errors.New("Error basket needs a name")
+	}
+	_, exists, err := readInstrument(stub, basket.Name)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, errors.New("Error basket name " + basket.Name + " is a registered instrument")
+	}
+	if len(in.Components) < 2 || len(in.Components) > maxBasketComponents {
+		return nil, errors.New("Error basket must have between 2 and 20 components")
+	}
+	for i := 0; i < len(in.Components); i++ {
+		instrument, err := getInstrument(stub, in.Components[i].Symbol)
+		if err != nil {
+			return nil, err
+		}
+		if !instrument.Tradable {
+			return nil, errors.New("Error instrument " + instrument.Symbol + " is not tradable")
+		}
+		if in.Components[i].Weight <= 0 {
+			return nil, errors.New("Error weight of " + instrument.Symbol + " must be positive")
+		}
+		if basket.has(instrument.Symbol) {
+			return nil, errors.New("Error " + instrument.Symbol + " appears twice in the basket")
+		}
+		basket.Components = append(basket.Components, BasketComponent{Symbol: instrument.Symbol, Weight: in.Components[i].Weight})
+	}
+	return &basket, nil
+}
+
+// stocks an option is written on, the components of a basket or its single stock
+func underlyingSymbols(tran Transaction) []string {
+	if tran.Basket != nil {
+		return tran.Basket.symbols()
+	}
+	return []string{tran.StockSymbol}
+}
+
+// true when exercise pays cash instead of delivering shares
+func cashSettled(tran Transaction) bool {
+	return tran.Path != nil || tran.Basket != nil
+}
+
+// checks that an option's underlying can be requested in the option's quantity
+func validateUnderlyingOrder(stub shim.ChaincodeStubInterface, tran Transaction) (Instrument, error) {
+	if tran.Basket == nil {
+		return validateInstrumentOrder(stub, tran.StockSymbol, tran.Quantity)
+	}
+	if tran.Quantity <= 0 {
+		return Instrument{}, errors.New("Error quantity must be positive")
+	}
+	return underlyingInstrument(stub, tran)
+}
+
+// instrument rules of an option's underlying, a basket trades in units of one with a cent tick
+func underlyingInstrument(stub shim.ChaincodeStubInterface, tran Transaction) (Instrument, error) {
+	if tran.Basket == nil {
+		return getInstrument(stub, tran.StockSymbol)
+	}
+	return Instrument{Symbol: tran.Basket.Name, Name: "Basket", LotSize: 1, TickSize: basketTickSize, Multiplier: 1, Tradable: true}, nil
+}
+
+// calendar of an option's underlying, a basket date must be a business day of every component's market
+func underlyingCalendar(stub shim.ChaincodeStubInterface, tran Transaction) (Calendar, error) {
+	symbols := underlyingSymbols(tran)
+	calendar, err := instrumentCalendar(stub, symbols[0])
+	if err != nil {
+		return calendar, err
+	}
+	for i := 1; i < len(symbols); i++ {
+		c, err := instrumentCalendar(stub, symbols[i])
+		if err != nil {
+			return calendar, err
+		}
+		if strings.Contains("/"+calendar.Market+"/", "/"+c.Market+"/") {
+			continue
+		}
+		calendar.Market = calendar.Market + "/" + c.Market
+		calendar.Holidays = append(append([]string{}, calendar.Holidays...), c.Holidays...)
+		if c.SettlementDays > calendar.SettlementDays {
+			calendar.SettlementDays = c.SettlementDays
+		}
+	}
+	return calendar, nil
+}
+
+func checkUnderlyingHalts(stub shim.ChaincodeStubInterface, tran Transaction, entityIDs ...string) error {
+	symbols := underlyingSymbols(tran)
+	for i := 0; i < len(symbols); i++ {
+		err := checkHalts(stub, symbols[i], entityIDs...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkUnderlyingCorporateActions(stub shim.ChaincodeStubInterface, tran Transaction, quotedAt time.Time) error {
+	symbols := underlyingSymbols(tran)
+	for i := 0; i < len(symbols); i++ {
+		err := checkCorporateActionTerms(stub, symbols[i], quotedAt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fresh market data of a stock or of a basket, whose spot and close are the weighted sums of its
+// components and whose volatility is their value weighted average, as if fully correlated
+func underlyingMarketData(stub shim.ChaincodeStubInterface, symbol string, basket *Basket) (MarketData, error) {
+	if basket == nil {
+		return getFreshMarketData(stub, symbol)
+	}
+	m := MarketData{Symbol: basket.Name}
+	volValue := 0.0
+	for i := 0; i < len(basket.Components); i++ {
+		c := basket.Components[i]
+		cm, err := getFreshMarketData(stub, c.Symbol)
+		if err != nil {
+			return m, err
+		}
+		m.Spot += c.Weight * cm.Spot
+		m.Close += c.Weight * cm.Close
+		volValue += c.Weight * cm.Spot * cm.Volatility
+		if m.Timestamp.IsZero() || cm.Timestamp.Before(m.Timestamp) {
+			m.Timestamp = cm.Timestamp
+		}
+	}
+	m.Volatility = volValue / m.Spot
+	return m, nil
+}
+
+// scales the weights of a basket holding the action's symbol so the basket keeps its value
+func adjustBasket(basket *Basket, action CorporateAction) *Basket {
+	b := Basket{Name: basket.Name}
+	for i := 0; i < len(basket.Components); i++ {
+		c := basket.Components[i]
+		if strings.EqualFold(c.Symbol, action.Symbol) {
+			switch action.Type {
+			case splitAction, reverseSplitAction, stockDividendAction:
+				c.Weight = c.Weight * float64(action.RatioNew) / float64(action.RatioOld)
+			case renameAction:
+				c.Symbol = action.NewSymbol
+			}
+		}
+		b.Components = append(b.Components, c)
+	}
+	return &b
+}
+
+// checks the position limits of an entity on every stock an option is written on, a basket is held
+// against the limits of its components, see splitExposure
+func checkUnderlyingLimits(stub shim.ChaincodeStubInterface, entity Entity, tran Transaction, notional float64, stage string) error {
+	parts := splitExposure(tran.StockSymbol, tran.Basket, tran.Quantity, notional)
+	for i := 0; i < len(parts); i++ {
+		err := checkPositionLimits(stub, entity, parts[i].Symbol, parts[i].Contracts, parts[i].Notional, tran.TransactionID, tran.TradeID, stage)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
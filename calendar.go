@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const calendarIndex = "calendar" // market -> Calendar
+const defaultMarket = "US"
+
+// expiry rules
+const thirdFridayExpiry = "ThirdFriday" // monthly expiry, the preceding business day when the third Friday is a holiday
+const anyBusinessDayExpiry = "AnyBusinessDay"
+
+type Calendar struct {
+	Market         string
+	Holidays       []string // YYYY-MM-DD sorted, weekends are never business days, dates are valid from the year of the first to that of the last
+	ExpiryRule     string   // ThirdFriday or AnyBusinessDay
+	SettlementDays int      // business days between exercise and delivery of the shares
+}
+
+// calendar written by init when missing, NYSE holidays of 2026 to 2028 and expiries on any business day,
+// the regulatory body opts a market into monthly expiries with setCalendar
+var defaultCalendar = Calendar{
+	Market: defaultMarket,
+	Holidays: []string{
+		"2026-01-01", "2026-01-19", "2026-02-16", "2026-04-03", "2026-05-25", "2026-06-19", "2026-07-03", "2026-09-07", "2026-11-26", "2026-12-25",
+		"2027-01-01", "2027-01-18", "2027-02-15", "2027-03-26", "2027-05-31", "2027-06-18", "2027-07-05", "2027-09-06", "2027-11-25", "2027-12-24",
+		"2028-01-17", "2028-02-21", "2028-04-14", "2028-05-29", "2028-06-19", "2028-07-04", "2028-09-04", "2028-11-23", "2028-12-25",
+	},
+	ExpiryRule:     anyBusinessDayExpiry,
+	SettlementDays: 2,
+}
+
+// whether the holidays of the calendar cover the year of d, business days cannot be told outside of it
+func (c Calendar) covers(d time.Time) bool {
+	if len(c.Holidays) == 0 {
+		return false
+	}
+	year := d.Format("2006")
+	return year >= c.Holidays[0][:4] && year <= c.Holidays[len(c.Holidays)-1][:4]
+}
+
+// rejects dates outside the covered years or not business days, what names the date in the error
+func (c Calendar) checkBusinessDay(d time.Time, what string) error {
+	if !c.covers(d) {
+		return errors.New("Error " + what + " " + d.Format("2006-01-02") + " is outside the years covered by the " + c.Market + " calendar")
+	}
+	if !c.isBusinessDay(d) {
+		return errors.New("Error " + what + " " + d.Format("2006-01-02") + " is not a business day in " + c.Market)
+	}
+	return nil
+}
+
+func (c Calendar) isBusinessDay(d time.Time) bool {
+	if d.Weekday() == time.Saturday || d.Weekday() == time.Sunday {
+		return false
+	}
+	date := d.Format("2006-01-02")
+	for i := 0; i < len(c.Holidays); i++ {
+		if c.Holidays[i] == date {
+			return false
+		}
+	}
+	return true
+}
+
+// date n business days after d, failing when it falls outside the covered years
+func (c Calendar) addBusinessDays(d time.Time, n int) (time.Time, error) {
+	d = time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, time.UTC)
+	for n > 0 {
+		d = d.AddDate(0, 0, 1)
+		if !c.covers(d) {
+			return d, errors.New("Error " + d.Format("2006-01-02") + " is outside the years covered by the " + c.Market + " calendar")
+		}
+		if c.isBusinessDay(d) {
+			n--
+		}
+	}
+	return d, nil
+}
+
+// standard monthly expiry, the third Friday or the business day before it
+func (c Calendar) standardExpiry(year int, month time.Month) time.Time {
+	d := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	for d.Weekday() != time.Friday {
+		d = d.AddDate(0, 0, 1)
+	}
+	d = d.AddDate(0, 0, 14)
+	for !c.isBusinessDay(d) {
+		d = d.AddDate(0, 0, -1)
+	}
+	return d
+}
+
+func (c Calendar) validateExpiry(d time.Time) error {
+	err := c.checkBusinessDay(d, "expiration date")
+	if err != nil {
+		return err
+	}
+	if c.ExpiryRule == thirdFridayExpiry {
+		expiry := c.standardExpiry(d.Year(), d.Month())
+		if !expiry.Equal(d) {
+			return errors.New("Error expiration date " + d.Format("2006-01-02") + " is not the standard expiry, expected " + expiry.Format("2006-01-02"))
+		}
+	}
+	return nil
+}
+
+// date from its parts, rejecting dates time.Date would normalize such as Feb 30
+func strictDate(year int, month int, day int) (time.Time, error) {
+	d := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	if d.Year() != year || int(d.Month()) != month || d.Day() != day {
+		return d, fmt.Errorf("Error invalid date %04d-%02d-%02d", year, month, day)
+	}
+	return d, nil
+}
+
+func writeCalendar(stub shim.ChaincodeStubInterface, calendar Calendar) error {
+	b, err := json.Marshal(calendar)
+	if err != nil {
+		return errors.New("Error while marshalling calendar")
+	}
+	return putIndexEntry(stub, b, calendarIndex, calendar.Market)
+}
+
+func seedCalendars(stub shim.ChaincodeStubInterface) error {
+	b, err := stub.GetState(indexKey(calendarIndex, defaultCalendar.Market))
+	if err != nil {
+		return errors.New("Error while getting calendar from ledger")
+	}
+	if len(b) != 0 {
+		return nil
+	}
+	return writeCalendar(stub, defaultCalendar)
+}
+
+func calendarFor(stub shim.ChaincodeStubInterface, market string) (Calendar, error) {
+	var calendar Calendar
+	if market == "" {
+		market = defaultMarket
+	}
+	b, err := stub.GetState(indexKey(calendarIndex, strings.ToUpper(market)))
+	if err != nil {
+		return calendar, errors.New("Error while getting calendar from ledger")
+	}
+	if len(b) == 0 {
+		return calendar, errors.New("Error no calendar for market " + market)
+	}
+	err = json.Unmarshal(b, &calendar)
+	if err != nil {
+		return calendar, errors.New("Error while unmarshalling calendar")
+	}
+	return calendar, nil
+}
+
+// calendar of the market an instrument trades on
+func instrumentCalendar(stub shim.ChaincodeStubInterface, symbol string) (Calendar, error) {
+	instrument, err := getInstrument(stub, symbol)
+	if err != nil {
+		return Calendar{}, err
+	}
+	return calendarFor(stub, instrument.Market)
+}
+
+// used by the regulatory body to add or replace a market calendar
+/*			arg 0	:	Market
+			arg 1	:	ThirdFriday/ AnyBusinessDay
+			arg 2	:	Settlement days after exercise
+			arg 3	:	Holidays as JSON, e.g. ["2027-01-01","2027-12-24"], covering the years from the first to the last
+			arg 4	:	RegBody EntityID
+*/
+func (t *SimpleChaincode) setCalendar(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 5 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	err := requireRegBody(stub, args[4], "maintain calendars")
+	if err != nil {
+		return nil, err
+	}
+	if args[0] == "" {
+		return nil, errors.New("Error calendar needs a market")
+	}
+	calendar := Calendar{Market: strings.ToUpper(args[0])}
+	switch strings.ToLower(args[1]) {
+	case strings.ToLower(thirdFridayExpiry):
+		calendar.ExpiryRule = thirdFridayExpiry
+	case strings.ToLower(anyBusinessDayExpiry):
+		calendar.ExpiryRule = anyBusinessDayExpiry
+	default:
+		return nil, errors.New("Error invalid expiry rule " + args[1])
+	}
+	calendar.SettlementDays, err = strconv.Atoi(args[2])
+	if err != nil || calendar.SettlementDays < 0 {
+		return nil, errors.New("Error invalid settlement days")
+	}
+	err = json.Unmarshal([]byte(args[3]), &calendar.Holidays)
+	if err != nil {
+		return nil, errors.New("Error invalid holidays")
+	}
+	if len(calendar.Holidays) == 0 {
+		return nil, errors.New("Error calendar needs the holidays of the years it covers")
+	}
+	for i := 0; i < len(calendar.Holidays); i++ {
+		_, err = time.Parse("2006-01-02", calendar.Holidays[i])
+		if err != nil {
+			return nil, errors.New("Error invalid holiday " + calendar.Holidays[i])
+		}
+	}
+	sort.Strings(calendar.Holidays)
+	return nil, writeCalendar(stub, calendar)
+}
+
+/*			arg 0	:	Market
+*/
+func (t *SimpleChaincode) getCalendar(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	calendar, err := calendarFor(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(calendar)
+	if err != nil {
+		return nil, errors.New("Error while marshalling calendar")
+	}
+	return b, nil
+}
+
+// next valid expiration dates of a symbol, monthly for ThirdFriday markets, up to the last covered year
+/*			arg 0	:	StockSymbol
+			arg 1	:	Number of dates
+*/
+func (t *SimpleChaincode) getExpiryDates(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	count, err := strconv.Atoi(args[1])
+	if err != nil || count <= 0 || count > 24 {
+		return nil, errors.New("Error invalid number of dates")
+	}
+	calendar, err := instrumentCalendar(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	var dates []string
+	if calendar.ExpiryRule == thirdFridayExpiry {
+		year, month := now.Year(), now.Month()
+		for len(dates) < count {
+			expiry := calendar.standardExpiry(year, month)
+			if !calendar.covers(expiry) {
+				break
+			}
+			if expiry.After(now) {
+				dates = append(dates, expiry.Format("2006-01-02"))
+			}
+			month++
+			if month > time.December {
+				month = time.January
+				year++
+			}
+		}
+	} else {
+		d := now
+		for len(dates) < count {
+			d, err = calendar.addBusinessDays(d, 1)
+			if err != nil {
+				break
+			}
+			dates = append(dates, d.Format("2006-01-02"))
+		}
+	}
+	b, err := json.Marshal(dates)
+	if err != nil {
+		return nil, errors.New("Error while marshalling expiry dates")
+	}
+	return b, nil
+}
@@ -0,0 +1,611 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// corporate action types
+const splitAction = "Split"
+const reverseSplitAction = "ReverseSplit"
+const cashDividendAction = "CashDividend"
+const stockDividendAction = "StockDividend"
+const renameAction = "Rename"
+
+// effect of a corporate action on one holder of the stock
+type Entitlement struct {
+	EntityID         string
+	SharesBefore     int
+	SharesAfter      int
+	FractionalShares float64 // shares lost to rounding down, owed as cash in lieu
+	Cash             float64 // dividend owed
+}
+
+type CorporateAction struct {
+	ActionID       string
+	TransactionID  string // CorporateAction transaction, also the transaction of the position movements
+	Type           string
+	Symbol         string
+	NewSymbol      string // Rename only
+	RatioNew       int    // Split, ReverseSplit and StockDividend: RatioNew shares for every RatioOld shares
+	RatioOld       int
+	Amount         float64 // CashDividend only, per share
+	EffectiveAt    time.Time
+	ActorID        string
+	Entitlements   []Entitlement
+	AdjustedTrades []string // open trades whose terms were adjusted
+}
+
+func actionType(s string) (string, error) {
+	for _, a := range []string{splitAction, reverseSplitAction, cashDividendAction, stockDividendAction, renameAction} {
+		if strings.EqualFold(s, a) {
+			return a, nil
+		}
+	}
+	return "", errors.New("Error invalid corporate action " + s)
+}
+
+// ratio of the form new:old, e.g. 2:1 for a two for one split
+func parseRatio(s string) (int, int, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, 0, errors.New("Error invalid ratio " + s)
+	}
+	n, err := strconv.Atoi(parts[0])
+	if err != nil || n <= 0 {
+		return 0, 0, errors.New("Error invalid ratio " + s)
+	}
+	m, err := strconv.Atoi(parts[1])
+	if err != nil || m <= 0 {
+		return 0, 0, errors.New("Error invalid ratio " + s)
+	}
+	return n, m, nil
+}
+
+// contracts and multiplier delivering n/m times the shares of an option, adjusting contracts first
+// and the multiplier otherwise, fails when neither gives whole numbers
+func adjustDeliverable(contracts int, multiplier int, n int, m int) (int, int, error) {
+	if multiplier <= 0 {
+		multiplier = 1
+	}
+	if (contracts*n)%m == 0 {
+		return contracts * n / m, multiplier, nil
+	}
+	if (multiplier*n)%m == 0 {
+		return contracts, multiplier * n / m, nil
+	}
+	return 0, 0, fmt.Errorf("Error %d contracts of %d shares cannot be adjusted by %d:%d", contracts, multiplier, n, m)
+}
+
+// adjusts an option of the action's symbol, or on a basket holding it, to the action's terms
+func adjustOption(o *Option, action CorporateAction) error {
+	if o.Basket != nil {
+		o.Basket = adjustBasket(o.Basket, action)
+		return nil
+	}
+	switch action.Type {
+	case splitAction, reverseSplitAction, stockDividendAction:
+		contracts, multiplier, err := adjustDeliverable(o.Quantity, o.Multiplier, action.RatioNew, action.RatioOld)
+		if err != nil {
+			return errors.New(err.Error() + " for trade " + o.TradeID)
+		}
+		o.Quantity = contracts
+		o.Multiplier = multiplier
+		o.StockRate = o.StockRate * float64(action.RatioOld) / float64(action.RatioNew)
+		o.OptionPrice = o.OptionPrice * float64(action.RatioOld) / float64(action.RatioNew)
+		if o.Barrier != nil {
+			b := *o.Barrier
+			b.Level = b.Level * float64(action.RatioOld) / float64(action.RatioNew)
+			b.Rebate = b.Rebate * float64(action.RatioOld) / float64(action.RatioNew)
+			o.Barrier = &b
+		}
+	case renameAction:
+		o.Symbol = action.NewSymbol
+	}
+	return nil
+}
+
+// adjusts the stock and options an entity holds on the action's symbol, ok is false when it holds none
+func adjustHoldings(entity *Entity, action *CorporateAction) (bool, error) {
+	changed := false
+	var portfolio []Stock
+	entitlement := Entitlement{EntityID: entity.EntityID}
+	held := false
+	for i := 0; i < len(entity.Portfolio); i++ {
+		s := entity.Portfolio[i]
+		if !strings.EqualFold(s.Symbol, action.Symbol) {
+			portfolio = append(portfolio, s)
+			continue
+		}
+		held = true
+		entitlement.SharesBefore += s.Quantity
+		switch action.Type {
+		case splitAction, reverseSplitAction, stockDividendAction:
+			s.Quantity = s.Quantity * action.RatioNew / action.RatioOld
+		case cashDividendAction:
+			entitlement.Cash += float64(s.Quantity) * action.Amount
+		case renameAction:
+			s.Symbol = action.NewSymbol
+		}
+		entitlement.SharesAfter += s.Quantity
+		portfolio = append(portfolio, s)
+		changed = true
+	}
+	if held {
+		if action.RatioOld > 0 {
+			entitlement.FractionalShares = float64(entitlement.SharesBefore*action.RatioNew%action.RatioOld) / float64(action.RatioOld)
+		}
+		action.Entitlements = append(action.Entitlements, entitlement)
+	}
+	// a rename onto a symbol already held merges both lines
+	if action.Type == renameAction {
+		var merged []Stock
+		for i := 0; i < len(portfolio); i++ {
+			found := false
+			for j := 0; j < len(merged); j++ {
+				if merged[j].Symbol == portfolio[i].Symbol {
+					merged[j].Quantity += portfolio[i].Quantity
+					found = true
+				}
+			}
+			if !found {
+				merged = append(merged, portfolio[i])
+			}
+		}
+		portfolio = merged
+	}
+	entity.Portfolio = portfolio
+
+	if action.Type == cashDividendAction {
+		return changed, nil
+	}
+	for i := 0; i < len(entity.Options); i++ {
+		o := entity.Options[i]
+		if !strings.EqualFold(o.Symbol, action.Symbol) && (o.Basket == nil || !o.Basket.has(action.Symbol)) {
+			continue
+		}
+		err := adjustOption(&entity.Options[i], *action)
+		if err != nil {
+			return false, err
+		}
+		changed = true
+	}
+	return changed, nil
+}
+
+// writes an Adjust transaction carrying the adjusted terms of an open trade, which tradeSet settles on
+func adjustTrade(stub shim.ChaincodeStubInterface, tradeID string, action CorporateAction, transactionID string) error {
+	tradebyte, err := stub.GetState(tradeID)
+	if err != nil {
+		return errors.New("Error while getting trade info from ledger")
+	}
+	var trade Trade
+	err = json.Unmarshal(tradebyte, &trade)
+	if err != nil {
+		return errors.New("Error while unmarshalling trade data")
+	}
+	termsbyte, err := stub.GetState(trade.TransactionHistory[len(trade.TransactionHistory)-1])
+	if err != nil {
+		return errors.New("Error while getting transaction from ledger")
+	}
+	var terms Transaction
+	err = json.Unmarshal(termsbyte, &terms)
+	if err != nil {
+		return errors.New("Error while unmarshalling transaction data")
+	}
+	o := Option{Symbol: terms.StockSymbol, Quantity: terms.Quantity, Multiplier: terms.Multiplier, StockRate: terms.StockRate, OptionPrice: terms.OptionPrice, TradeID: tradeID, Barrier: terms.Barrier, Basket: terms.Basket}
+	err = adjustOption(&o, action)
+	if err != nil {
+		return err
+	}
+	t := terms
+	t.TransactionID = transactionID
+	t.TransactionType = "Adjust"
+	t.StockSymbol = o.Symbol
+	t.Quantity = o.Quantity
+	t.Multiplier = o.Multiplier
+	t.StockRate = o.StockRate
+	t.OptionPrice = o.OptionPrice
+	t.Barrier = o.Barrier
+	t.Basket = o.Basket
+	t.Timestamp = action.EffectiveAt
+	t.ActorID = action.ActorID
+	t.Status = "Success"
+	err = writeTransaction(stub, t)
+	if err != nil {
+		return err
+	}
+
+	if action.Type == renameAction && trade.Basket != nil {
+		err = delIndexEntry(stub, basketIndex, symbolIndexValue(action.Symbol), trade.TradeID)
+		if err != nil {
+			return err
+		}
+		err = putIndexEntry(stub, nil, basketIndex, symbolIndexValue(action.NewSymbol), trade.TradeID)
+		if err != nil {
+			return err
+		}
+	} else if action.Type == renameAction {
+		err = delIndexEntry(stub, symbolIndex, symbolIndexValue(trade.Symbol), trade.TradeID)
+		if err != nil {
+			return err
+		}
+		err = putIndexEntry(stub, nil, symbolIndex, symbolIndexValue(o.Symbol), trade.TradeID)
+		if err != nil {
+			return err
+		}
+		if o.Barrier != nil && o.Barrier.Status == barrierLive {
+			err = delIndexEntry(stub, liveBarrierIndex, symbolIndexValue(trade.Symbol), trade.TradeID)
+			if err != nil {
+				return err
+			}
+			err = putIndexEntry(stub, nil, liveBarrierIndex, symbolIndexValue(o.Symbol), trade.TradeID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	trade.Symbol = o.Symbol
+	trade.Quantity = o.Quantity
+	trade.Basket = o.Basket
+	trade.TransactionHistory = append(trade.TransactionHistory, t.TransactionID)
+	b, err := json.Marshal(trade)
+	if err != nil {
+		return errors.New("Error while marshalling trade data")
+	}
+	err = stub.PutState(trade.TradeID, b)
+	if err != nil {
+		return errors.New("Error while writing trade to ledger")
+	}
+	return nil
+}
+
+// carries the latest market data of the symbol over to its adjusted prices or new symbol
+func adjustMarketData(stub shim.ChaincodeStubInterface, action CorporateAction) error {
+	m, err := readMarketData(stub, action.Symbol)
+	if err != nil {
+		// nothing published yet
+		return nil
+	}
+	switch action.Type {
+	case splitAction, reverseSplitAction, stockDividendAction:
+		m.Spot = m.Spot * float64(action.RatioOld) / float64(action.RatioNew)
+		m.Close = m.Close * float64(action.RatioOld) / float64(action.RatioNew)
+	case renameAction:
+		m.Symbol = action.NewSymbol
+	default:
+		return nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return errors.New("Error while marshalling market data")
+	}
+	err = stub.PutState(marketDataKey(m.Symbol), b)
+	if err != nil {
+		return errors.New("Error while writing market data to ledger")
+	}
+	return nil
+}
+
+// restates the recorded fixings of the symbol so Asian and lookback options settle on adjusted prices
+func adjustFixings(stub shim.ChaincodeStubInterface, action CorporateAction) error {
+	if action.Type == cashDividendAction {
+		return nil
+	}
+	fixings, err := readFixings(stub, action.Symbol)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(fixings); i++ {
+		f := fixings[i]
+		switch action.Type {
+		case splitAction, reverseSplitAction, stockDividendAction:
+			f.Price = f.Price * float64(action.RatioOld) / float64(action.RatioNew)
+		case renameAction:
+			err = delIndexEntry(stub, fixingIndex, strings.ToUpper(f.Symbol), f.Date)
+			if err != nil {
+				return err
+			}
+			f.Symbol = action.NewSymbol
+		}
+		err = writeFixing(stub, f)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// factor restating a price of the symbol published at a time to the terms of the splits, reverse splits
+// and stock dividends that took effect after it
+func splitFactorSince(stub shim.ChaincodeStubInterface, symbol string, at time.Time) (float64, error) {
+	numbyte, err := stub.GetState("currentCorporateActionNum")
+	if err != nil {
+		return 0, errors.New("Error while getting currentCorporateActionNum from ledger")
+	}
+	num, _ := strconv.Atoi(string(numbyte))
+	factor := 1.0
+	for ; num > 1000; num-- {
+		b, err := stub.GetState("corpAction" + strconv.Itoa(num))
+		if err != nil {
+			return 0, errors.New("Error while getting corporate action from ledger")
+		}
+		var action CorporateAction
+		err = json.Unmarshal(b, &action)
+		if err != nil {
+			return 0, errors.New("Error while unmarshalling corporate action")
+		}
+		if !strings.EqualFold(action.Symbol, symbol) || !action.EffectiveAt.After(at) {
+			continue
+		}
+		switch action.Type {
+		case splitAction, reverseSplitAction, stockDividendAction:
+			factor = factor * float64(action.RatioOld) / float64(action.RatioNew)
+		}
+	}
+	return factor, nil
+}
+
+// error when a quote or quote request of the symbol was made before its last corporate action
+func checkCorporateActionTerms(stub shim.ChaincodeStubInterface, symbol string, quotedAt time.Time) error {
+	instrument, err := getInstrument(stub, symbol)
+	if err != nil {
+		return err
+	}
+	if !instrument.LastActionAt.IsZero() && quotedAt.Before(instrument.LastActionAt) {
+		return errors.New("Error terms of " + instrument.Symbol + " changed by a corporate action, request a new quote")
+	}
+	return nil
+}
+
+// used by the regulatory body to apply a corporate action to every holder and open trade of a stock
+/*			arg 0	:	Split/ ReverseSplit/ CashDividend/ StockDividend/ Rename
+			arg 1	:	StockSymbol
+			arg 2	:	Ratio new:old for Split, ReverseSplit and StockDividend (e.g. 2:1, 1:10, 21:20),
+						amount per share for CashDividend, new StockSymbol for Rename
+			arg 3	:	RegBody EntityID
+*/
+func (t *SimpleChaincode) applyCorporateAction(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	err := requireRegBody(stub, args[3], "apply corporate actions")
+	if err != nil {
+		return nil, err
+	}
+	kind, err := actionType(args[0])
+	if err != nil {
+		return nil, err
+	}
+	instrument, err := getInstrument(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	action := CorporateAction{Type: kind, Symbol: instrument.Symbol, EffectiveAt: now, ActorID: args[3]}
+	switch kind {
+	case splitAction, reverseSplitAction, stockDividendAction:
+		action.RatioNew, action.RatioOld, err = parseRatio(args[2])
+		if err != nil {
+			return nil, err
+		}
+		if kind == reverseSplitAction && action.RatioNew >= action.RatioOld {
+			return nil, errors.New("Error reverse split ratio must reduce the number of shares")
+		}
+		if kind != reverseSplitAction && action.RatioNew <= action.RatioOld {
+			return nil, errors.New("Error " + strings.ToLower(kind) + " ratio must increase the number of shares")
+		}
+	case cashDividendAction:
+		action.Amount, err = strconv.ParseFloat(args[2], 64)
+		if err != nil || action.Amount <= 0 {
+			return nil, errors.New("Error invalid dividend amount")
+		}
+	case renameAction:
+		action.NewSymbol = strings.ToUpper(args[2])
+		if action.NewSymbol == "" || action.NewSymbol == action.Symbol {
+			return nil, errors.New("Error invalid new symbol " + args[2])
+		}
+		_, exists, err := readInstrument(stub, action.NewSymbol)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			return nil, errors.New("Error instrument " + action.NewSymbol + " already exists")
+		}
+	}
+
+	ctidByte, err := stub.GetState("currentTransactionNum")
+	if err != nil {
+		return nil, errors.New("Error while getting currentTransactionNum from ledger")
+	}
+	tid, err := strconv.Atoi(string(ctidByte))
+	if err != nil {
+		return nil, errors.New("Error while converting ctidByte to integer")
+	}
+	tid++
+	action.TransactionID = "trans" + strconv.Itoa(tid)
+
+	// adjust holders, all of them are computed before anything is written
+	var allEntities []string
+	listbyte, err := stub.GetState("entityList")
+	if err != nil {
+		return nil, errors.New("Error while getting entity list from ledger")
+	}
+	err = json.Unmarshal(listbyte, &allEntities)
+	if err != nil {
+		return nil, errors.New("Error while unmarshalling entity data")
+	}
+	var adjusted []Entity
+	for i := 0; i < len(allEntities); i++ {
+		entity, err := getEntity(stub, allEntities[i])
+		if err != nil {
+			return nil, err
+		}
+		changed, err := adjustHoldings(&entity, &action)
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			adjusted = append(adjusted, entity)
+		}
+	}
+	for i := 0; i < len(adjusted); i++ {
+		err = recordPositionChanges(stub, adjusted[i], action.TransactionID, "")
+		if err != nil {
+			return nil, err
+		}
+		b, err := json.Marshal(adjusted[i])
+		if err != nil {
+			return nil, errors.New("Error while marshalling entity data")
+		}
+		err = stub.PutState(adjusted[i].EntityID, b)
+		if err != nil {
+			return nil, errors.New("Error while writing entity to ledger")
+		}
+	}
+
+	// open trades settle on the adjusted terms, cash dividends leave option terms unchanged
+	if kind != cashDividendAction {
+		tradeIDs, err := tradeIDsFromIndex(stub, 0, symbolIndex, symbolIndexValue(action.Symbol))
+		if err != nil {
+			return nil, err
+		}
+		basketTradeIDs, err := tradeIDsFromIndex(stub, 0, basketIndex, symbolIndexValue(action.Symbol))
+		if err != nil {
+			return nil, err
+		}
+		tradeIDs = append(tradeIDs, basketTradeIDs...)
+		for i := len(tradeIDs) - 1; i >= 0; i-- {
+			tradebyte, err := stub.GetState(tradeIDs[i])
+			if err != nil {
+				return nil, errors.New("Error while getting trade info from ledger")
+			}
+			var trade Trade
+			err = json.Unmarshal(tradebyte, &trade)
+			if err != nil {
+				return nil, errors.New("Error while unmarshalling trade data")
+			}
+			if trade.Status != "Trade Executed" {
+				continue
+			}
+			tid++
+			err = adjustTrade(stub, trade.TradeID, action, "trans"+strconv.Itoa(tid))
+			if err != nil {
+				return nil, err
+			}
+			action.AdjustedTrades = append(action.AdjustedTrades, trade.TradeID)
+		}
+	}
+
+	tran := Transaction{
+		TransactionID:   action.TransactionID,
+		TransactionType: "CorporateAction",
+		StockSymbol:     action.Symbol,
+		Timestamp:       action.EffectiveAt,
+		ActorID:         action.ActorID,
+		Status:          "Success",
+	}
+	err = writeTransaction(stub, tran)
+	if err != nil {
+		return nil, err
+	}
+	err = stub.PutState("currentTransactionNum", []byte(strconv.Itoa(tid)))
+	if err != nil {
+		return nil, errors.New("Error while writing currentTransactionNum to ledger")
+	}
+
+	// quotes made before the action are no longer valid
+	instrument.LastActionAt = action.EffectiveAt
+	if kind == renameAction {
+		renamed := instrument
+		renamed.Symbol = action.NewSymbol
+		err = writeInstrument(stub, renamed)
+		if err != nil {
+			return nil, err
+		}
+		instrument.Tradable = false
+	}
+	err = writeInstrument(stub, instrument)
+	if err != nil {
+		return nil, err
+	}
+	err = adjustMarketData(stub, action)
+	if err != nil {
+		return nil, err
+	}
+	err = adjustFixings(stub, action)
+	if err != nil {
+		return nil, err
+	}
+
+	num := 1000
+	numbyte, err := stub.GetState("currentCorporateActionNum")
+	if err != nil {
+		return nil, errors.New("Error while getting currentCorporateActionNum from ledger")
+	}
+	if len(numbyte) != 0 {
+		num, err = strconv.Atoi(string(numbyte))
+		if err != nil {
+			return nil, errors.New("Error while converting currentCorporateActionNum to integer")
+		}
+	}
+	num++
+	action.ActionID = "corpAction" + strconv.Itoa(num)
+	b, err := json.Marshal(action)
+	if err != nil {
+		return nil, errors.New("Error while marshalling corporate action")
+	}
+	err = stub.PutState(action.ActionID, b)
+	if err != nil {
+		return nil, errors.New("Error while writing corporate action to ledger")
+	}
+	err = stub.PutState("currentCorporateActionNum", []byte(strconv.Itoa(num)))
+	if err != nil {
+		return nil, errors.New("Error while writing currentCorporateActionNum to ledger")
+	}
+	return []byte(action.ActionID), nil
+}
+
+// corporate actions newest first, all of them or those of a symbol
+/*			arg 0	:	StockSymbol (optional)
+*/
+func (t *SimpleChaincode) getCorporateActions(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) > 1 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	numbyte, err := stub.GetState("currentCorporateActionNum")
+	if err != nil {
+		return nil, errors.New("Error while getting currentCorporateActionNum from ledger")
+	}
+	num, _ := strconv.Atoi(string(numbyte))
+	actions := []CorporateAction{}
+	for ; num > 1000; num-- {
+		b, err := stub.GetState("corpAction" + strconv.Itoa(num))
+		if err != nil {
+			return nil, errors.New("Error while getting corporate action from ledger")
+		}
+		var action CorporateAction
+		err = json.Unmarshal(b, &action)
+		if err != nil {
+			return nil, errors.New("Error while unmarshalling corporate action")
+		}
+		if len(args) == 1 && !strings.EqualFold(action.Symbol, args[0]) && !strings.EqualFold(action.NewSymbol, args[0]) {
+			continue
+		}
+		actions = append(actions, action)
+	}
+	b, err := json.Marshal(actions)
+	if err != nil {
+		return nil, errors.New("Error while marshalling corporate actions")
+	}
+	return b, nil
+}
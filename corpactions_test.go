@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestSplitAdjustsOpenOption(t *testing.T) {
+	cc := new(SimpleChaincode)
+	stub := newTestLedger(t, time.Date(2026, 3, 2, 15, 0, 0, 0, time.UTC))
+	_, err := cc.publishMarketData(stub, []string{"AAPL", "130", "128", "0.25", entity5})
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	tradeID := executeTestCall(t, stub, "AAPL", "3", "14.68", "130")
+
+	_, err = cc.applyCorporateAction(stub, []string{"Split", "AAPL", "2:1", entity1})
+	if err == nil {
+		t.Errorf("expected an error for a corporate action applied by a client")
+	}
+	_, err = cc.applyCorporateAction(stub, []string{"Split", "AAPL", "2:1", entity4})
+	if err != nil {
+		t.Fatalf("split: %v", err)
+	}
+
+	// the trade settles on the terms of its Adjust transaction, twice the contracts at half the strike
+	var trade Trade
+	_ = json.Unmarshal(stub.state[tradeID], &trade)
+	terms, err := currentTerms(stub, trade)
+	if err != nil {
+		t.Fatalf("terms: %v", err)
+	}
+	if terms.TransactionType != "Adjust" || terms.Quantity != 6 || terms.Multiplier != 1 || !within(terms.StockRate, 65, 1e-9) || !within(terms.OptionPrice, 7.34, 1e-9) {
+		t.Errorf("adjusted terms %+v, want 6 contracts struck at 65 for 7.34", terms)
+	}
+	if trade.Quantity != 6 {
+		t.Errorf("trade quantity %d, want 6", trade.Quantity)
+	}
+
+	// both sides hold the adjusted option, the client's shares are doubled
+	for _, entityID := range []string{entity1, entity2} {
+		entity, err := getEntity(stub, entityID)
+		if err != nil {
+			t.Fatalf("entity: %v", err)
+		}
+		found := false
+		for _, o := range entity.Options {
+			if o.TradeID == tradeID {
+				found = true
+				if o.Quantity != 6 || !within(o.StockRate, 65, 1e-9) {
+					t.Errorf("%s holds %d contracts struck at %.2f, want 6 at 65", entityID, o.Quantity, o.StockRate)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("%s does not hold the option of %s", entityID, tradeID)
+		}
+	}
+	client, _ := getEntity(stub, entity1)
+	for _, s := range client.Portfolio {
+		if s.Symbol == "AAPL" && s.Quantity != 40 {
+			t.Errorf("client holds %d AAPL, want 40", s.Quantity)
+		}
+	}
+	m, _ := readMarketData(stub, "AAPL")
+	if !within(m.Spot, 65, 1e-9) {
+		t.Errorf("spot %.2f, want 65", m.Spot)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"crypto/x509"
 	"strings"
+	"sort"
 	"time"
 )
 type Stock struct{
@@ -15,13 +16,20 @@ type Stock struct{
 }
 type Option struct{
 	Symbol string
-	Quantity int
+	Quantity int				// number of contracts
+	Multiplier int				// shares per contract, 0 for options held before contracts (1 share)
 	OptionType string
 	StockRate float64
 	SettlementDate time.Time	
 	OptionPrice float64
 	EntityID string
 	TradeID string
+	StrategyID string			// strategy the option is a leg of, empty for single options
+	Side string					// Sell when the client sold the option in a strategy, the bank holds it
+	Barrier *Barrier `json:",omitempty"`	// knock-in or knock-out terms, nil for vanilla options
+	Path *PathTerms `json:",omitempty"`	// Asian or lookback fixing schedule, nil for vanilla options
+	Basket *Basket `json:",omitempty"`	// components of a basket option, Symbol is the basket's name
+	Swap *SwapTerms `json:",omitempty"`	// reset schedule and financing of a swap, nil for options and forwards
 }
 type Entity struct{
 	EntityID string				// enrollmentID
@@ -34,24 +42,42 @@ type Entity struct{
 type Trade struct				
 {
 	TradeID string				// rfq transaction id
+	StrategyID string			// strategy the trade is a leg of, empty for single options
 	Symbol string
 	Quantity int
-	TradeType string			// Call/ Put
+	TradeType string			// Call/ Put/ Forward/ Swap
+	Side string					// Buy/ Sell seen from the client for the legs of a strategy, empty for single options
+	ClientID string				// entityId of client
+	BankID string				// entityId of the bank the trade was executed with
+	CreatedAt time.Time			// time of the rfq
 	TransactionHistory []string // transactions belonging to this trade
 	Status string				// "Quote requested" or "Responded" or "Trade executed" or "Trade exercised" or "Trade timed out"
+	Basket *Basket `json:",omitempty"`	// components of a basket option, Symbol is the basket's name
 }
 type Transaction struct{		// ledger transactions
 	TransactionID string		// different for every transaction
 	TradeID string				// same for all transactions corresponding to a single trade
-	TransactionType string		// type of transaction rfq or resp or tradeExec or tradeSet	   Request	Response Execute	Exercise
-	OptionType string    		// Call/ Put
+	StrategyID string			// strategy the trade is a leg of, empty for single options
+	TransactionType string		// type of transaction rfq or resp or tradeExec or tradeSet	   Request	Response Execute	Exercise	Expire	Cancel	Settle	Reset
+	OptionType string    		// Call/ Put/ Forward/ Swap
+	Side string					// Buy/ Sell seen from the client, empty for Buy
 	ClientID string				// entityId of client
 	BankID string				// entityId of bank1 or bank2
 	StockSymbol string				
-	Quantity int
-	OptionPrice float64
+	Quantity int				// number of contracts
+	Multiplier int				// shares per contract, 0 for transactions written before contracts (1 share)
+	OptionPrice float64			// premium per share
 	StockRate float64	
 	SettlementDate time.Time	
+	Barrier *Barrier `json:",omitempty"`	// knock-in or knock-out terms, nil for vanilla options
+	Path *PathTerms `json:",omitempty"`	// Asian or lookback fixing schedule, nil for vanilla options
+	Basket *Basket `json:",omitempty"`	// components of a basket option, StockSymbol is the basket's name
+	Swap *SwapTerms `json:",omitempty"`	// reset schedule and financing of a swap, nil for options and forwards
+	SpotPrice float64			// published spot at exercise, 0 if none was available
+	DeliveryDate time.Time		// date exercised shares settle, T+N business days after exercise
+	CashSettlement float64		// owed to the holder on exercise of cash-settled options instead of delivering shares, to the client on swap resets; recorded only, paid outside the ledger
+	Timestamp time.Time			// time the transaction was written
+	ActorID string				// entityId of the entity that submitted the transaction
 	Status string
 }
 
@@ -61,6 +87,7 @@ const entity1 = "user_type1_0"
 const entity2 = "user_type1_1"
 const entity3 = "user_type1_2"
 const entity4 = "user_type1_3"
+const entity5 = "user_type1_4"
 
 
 type SimpleChaincode struct {
@@ -121,7 +148,19 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string
 		return nil, err
 	}
 	
-	EntityList := []string{entity1,entity2, entity3, entity4}
+	pricePublisher:= Entity{
+		EntityID: entity5,
+		EntityName:	"Market Data Feed",
+		EntityType: "PricePublisher",
+	}
+	b, err = json.Marshal(pricePublisher)
+	if err == nil {
+		err = stub.PutState(pricePublisher.EntityID,b)
+    } else {
+		return nil, err
+	}
+	
+	EntityList := []string{entity1,entity2, entity3, entity4, entity5}
 
 	b, err = json.Marshal(EntityList)
 	if err == nil {
@@ -130,6 +169,25 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string
 		return nil, err
 	}
 	
+	// reference data of tradable symbols and their market calendars
+	err = seedInstruments(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = seedCalendars(stub)
+	if err != nil {
+		return nil, err
+	}
+	
+	// record opening balances as position movements
+	entities := []Entity{client, bank1, bank2, regBody, pricePublisher}
+	for i:=0; i<len(entities); i++ {
+		err = syncPositionMovements(stub, entities[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	
 	// initialize trade num and transaction num
 	byteVal, err := stub.GetState("currentTransactionNum")
 	if len(byteVal) == 0 {
@@ -164,6 +222,38 @@ func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface, function stri
         return t.tradeSet(stub, args)
     } else if function == "trial" {
         return t.trial(stub, args)
+    } else if function == "publishMarketData" {
+        return t.publishMarketData(stub, args)
+    } else if function == "setQuoteRule" {
+        return t.setQuoteRule(stub, args)
+    } else if function == "snapshotPnL" {
+        return t.snapshotPnL(stub, args)
+    } else if function == "rebuildIndexes" {
+        return t.rebuildIndexes(stub, args)
+    } else if function == "cancelQuoteRequest" {
+        return t.cancelQuoteRequest(stub, args)
+    } else if function == "setPositionLimit" {
+        return t.setPositionLimit(stub, args)
+    } else if function == "setRelatedEntities" {
+        return t.setRelatedEntities(stub, args)
+    } else if function == "setSurveillanceConfig" {
+        return t.setSurveillanceConfig(stub, args)
+    } else if function == "dispositionAlert" {
+        return t.dispositionAlert(stub, args)
+    } else if function == "haltTrading" {
+        return t.haltTrading(stub, args)
+    } else if function == "resumeTrading" {
+        return t.resumeTrading(stub, args)
+    } else if function == "setInstrument" {
+        return t.setInstrument(stub, args)
+    } else if function == "setCalendar" {
+        return t.setCalendar(stub, args)
+    } else if function == "applyCorporateAction" {
+        return t.applyCorporateAction(stub, args)
+    } else if function == "recordFixing" {
+        return t.recordFixing(stub, args)
+    } else if function == "resetSwap" {
+        return t.resetSwap(stub, args)
     } 
     fmt.Println("invoke did not find func: " + function)
     return nil, errors.New("Received unknown function invocation")
@@ -192,6 +282,54 @@ func (t *SimpleChaincode) Query(stub shim.ChaincodeStubInterface, function strin
         return t.getEntityList(stub, args)
     }	else if function == "getTransactionStatus" {
         return t.getTransactionStatus(stub, args)
+    }	else if function == "priceOption" {
+        return t.priceOption(stub, args)
+    }	else if function == "getRiskReport" {
+        return t.getRiskReport(stub, args)
+    }	else if function == "getMarketData" {
+        return t.getMarketData(stub, args)
+    }	else if function == "getMarketDataHistory" {
+        return t.getMarketDataHistory(stub, args)
+    }	else if function == "getQuoteRule" {
+        return t.getQuoteRule(stub, args)
+    }	else if function == "getValuation" {
+        return t.getValuation(stub, args)
+    }	else if function == "getPnLHistory" {
+        return t.getPnLHistory(stub, args)
+    }	else if function == "queryTrades" {
+        return t.queryTrades(stub, args)
+    }	else if function == "getTradeAudit" {
+        return t.getTradeAudit(stub, args)
+    }	else if function == "getPositionHistory" {
+        return t.getPositionHistory(stub, args)
+    }	else if function == "getPositionsAsOf" {
+        return t.getPositionsAsOf(stub, args)
+    }	else if function == "reconcilePositions" {
+        return t.reconcilePositions(stub, args)
+    }	else if function == "getRegulatorReport" {
+        return t.getRegulatorReport(stub, args)
+    }	else if function == "getPositionLimits" {
+        return t.getPositionLimits(stub, args)
+    }	else if function == "getLimitBreaches" {
+        return t.getLimitBreaches(stub, args)
+    }	else if function == "getAlerts" {
+        return t.getAlerts(stub, args)
+    }	else if function == "getActiveHalts" {
+        return t.getActiveHalts(stub, args)
+    }	else if function == "getHaltHistory" {
+        return t.getHaltHistory(stub, args)
+    }	else if function == "getInstruments" {
+        return t.getInstruments(stub, args)
+    }	else if function == "getCalendar" {
+        return t.getCalendar(stub, args)
+    }	else if function == "getExpiryDates" {
+        return t.getExpiryDates(stub, args)
+    }	else if function == "getCorporateActions" {
+        return t.getCorporateActions(stub, args)
+    }	else if function == "getStrategy" {
+        return t.getStrategy(stub, args)
+    }	else if function == "getFixings" {
+        return t.getFixings(stub, args)
     }
 	fmt.Println("query did not find func: " + function)
     return nil, errors.New("Received unknown function query")
@@ -245,26 +383,43 @@ func (t *SimpleChaincode) readTransaction(stub shim.ChaincodeStubInterface, args
 		return nil, errors.New("Error while unmarshalling entity data")
 	}
 	
-	switch entity.EntityType {
-		case "RegBody":	return valAsbytes, nil
-		case "Client":	if tran.ClientID == args[1] {
-							return valAsbytes, nil
-						}
-		case "Bank":	if tran.TransactionType == "Request" {
-							return valAsbytes, nil
-						} else if tran.BankID == args[1] {
-							return valAsbytes, nil
-						}
+	if canReadTransaction(entity, tran) {
+		return valAsbytes, nil
 	}
     return nil, nil
 }
+// check entity type and accordingly allow transaction to be read
+func canReadTransaction(entity Entity, tran Transaction) bool {
+	switch entity.EntityType {
+		case "RegBody":	return true
+		case "Client":	return tran.ClientID == entity.EntityID
+		case "Bank":	return tran.TransactionType == "Request" || tran.BankID == entity.EntityID
+	}
+	return false
+}
 // used by client to request for quotes for a particular stock, adds rfq transaction to ledger
 /*			arg 0	:	OptionType
 			arg 1	:	StockSymbol
-			arg 2	:	Quantity
+			arg 2	:	Quantity in contracts, a multiple of the instrument's lot size
+			arg 3	:	ClientID
+			arg 4	:	Barrier or fixing schedule as JSON (optional)
+						barrier, e.g. {"Type":"UpAndOut","Level":150,"Rebate":1.5}
+						types UpAndOut/ DownAndOut/ UpAndIn/ DownAndIn, the rebate is per share
+						fixing schedule, e.g. {"Type":"AveragePrice","FixingDates":["2026-11-16","2026-12-16"]}
+						types AveragePrice/ AverageStrike/ FixedLookback/ FloatingLookback, settled in cash
+						basket, e.g. {"Type":"Basket","Components":[{"Symbol":"AAPL","Weight":2},{"Symbol":"MSFT","Weight":1}]}
+						arg 1 names the basket, weights are shares per basket unit, settled in cash
+		or, for forwards and total return swaps, arg 0 is Forward/ Swap and arg 4 the swap's reset schedule (optional)
+						e.g. {"ResetDates":["2026-12-16","2027-03-17"]}, forwards take no terms
+		or, for multi-leg strategies, see requestStrategyQuote
+			arg 0	:	Spread/ Straddle/ Strangle/ Collar/ Custom
+			arg 1	:	Legs as JSON
 */
 func (t *SimpleChaincode) requestForQuote(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	if len(args)== 4{
+	if len(args)== 3 {
+		return t.requestStrategyQuote(stub, args)
+	}
+	if len(args)== 4 || len(args)== 5 {
 		// get current Transaction number
 		ctidByte, err := stub.GetState("currentTransactionNum")
 		if(err != nil){
@@ -307,6 +462,11 @@ func (t *SimpleChaincode) requestForQuote(stub shim.ChaincodeStubInterface, args
 			return nil, nil
 		}
 		tradeID = tradeID + 1
+		now, err := txTime(stub)
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
+		}
 		
 		//Transaction
 		t := Transaction{
@@ -320,6 +480,8 @@ func (t *SimpleChaincode) requestForQuote(stub shim.ChaincodeStubInterface, args
 		Quantity:	q,								// based on input
 		OptionPrice: 0,
 		StockRate: 0,
+		Timestamp: now,
+		ActorID: args[3],
 		Status: "Success",
 		}
 		//Trade
@@ -328,6 +490,45 @@ func (t *SimpleChaincode) requestForQuote(stub shim.ChaincodeStubInterface, args
 		Symbol: t.StockSymbol,
 		Quantity: t.Quantity,
 		TradeType: t.OptionType,
+		ClientID: t.ClientID,
+		CreatedAt: now,
+		}
+
+		// the product checks the terms of the request and stores them in their canonical form
+		product, err := productFor(t.OptionType)
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
+		}
+		err = product.ValidateRequest(stub, &t, args[4:])
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
+		}
+		tr.TradeType = t.OptionType
+		tr.Symbol = t.StockSymbol
+		tr.Basket = t.Basket
+
+		err = checkUnderlyingHalts(stub, t, t.ClientID)
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
+		}
+
+		// check the client's position limits, notional is estimated at the published spot if any
+		client, err := getEntity(stub, t.ClientID)
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
+		}
+		estimate := 0.0
+		if m, err := underlyingMarketData(stub, t.StockSymbol, t.Basket); err == nil {
+			estimate = m.Spot * float64(shares(t.Quantity, t.Multiplier))
+		}
+		err = checkUnderlyingLimits(stub, client, t, estimate, "Request")
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
 		}
 
 		// convert to Transaction to JSON
@@ -357,7 +558,20 @@ func (t *SimpleChaincode) requestForQuote(stub shim.ChaincodeStubInterface, args
 			_ = updateTransactionStatus(stub, transactionID, "Error while marshalling trade data")
 			return nil, nil
 		}
-		
+
+		// index trade by symbol and client
+		err = indexTrade(stub, tr)
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
+		}
+		// deliver rfq to the banks
+		err = addToInboxes(stub, t.TradeID, t.TransactionID)
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
+		}
+
 		// update currentTransactionNum
 		err = stub.PutState("currentTransactionNum", []byte(strconv.Itoa(tid)))
 		if err != nil {
@@ -385,6 +599,11 @@ func (t *SimpleChaincode) requestForQuote(stub shim.ChaincodeStubInterface, args
 			return nil, nil
 		}	
 		
+		err = emitTradeEvent(stub, newTradeEvent(t, "Quote requested"))
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
+		}
 		return []byte(t.TransactionID), nil
 	}
 	return nil, errors.New("Incorrect number of arguments")
@@ -396,8 +615,18 @@ func (t *SimpleChaincode) requestForQuote(stub shim.ChaincodeStubInterface, args
 			arg 4	:	SettlementDate Year
 			arg 5	:	SettlementDate Month
 			arg 6	:	SettlementDate Day
+		for forwards arg 2 is the upfront payment and arg 3 the forward price, settled at maturity
+		for swaps arg 2 is the annual financing rate in percent and arg 3 the initial reference price
+		or, for multi-leg strategies, see respondToStrategyQuote
+			arg 0	:	StrategyID
+			arg 1	:	Leg quotes as JSON
+			arg 2	:	Net premium, empty to price every leg
+			arg 3-5	:	SettlementDate Year, Month, Day
 */
 func (t *SimpleChaincode) respondToQuote(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args)== 7 {
+		return t.respondToStrategyQuote(stub, args)
+	}
 	if len(args)== 8 {
 		tradeID := args[0]
 		quoteID := args[1]
@@ -442,11 +671,21 @@ func (t *SimpleChaincode) respondToQuote(stub shim.ChaincodeStubInterface, args
 			_ = updateTransactionStatus(stub, transactionID, "Error due to mismatch in tradeIDs")
 			return nil, nil
 		}		
+		if rfq.StrategyID != "" {
+			_ = updateTransactionStatus(stub, transactionID, "Error trade "+tradeID+" is a leg of "+rfq.StrategyID+", respond to the strategy")
+			return nil, nil
+		}
 		
-		// add trade to bank's trade history
-		err = updateTradeHistory(stub, args[7], tradeID)
+		err = checkUnderlyingHalts(stub, rfq, rfq.ClientID, args[7])
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while updating trade history")
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
+		}
+		
+		// the request was made on terms a corporate action has since changed
+		err = checkUnderlyingCorporateActions(stub, rfq, rfq.Timestamp)
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
 			return nil, nil
 		}
 		
@@ -497,7 +736,6 @@ func (t *SimpleChaincode) respondToQuote(stub shim.ChaincodeStubInterface, args
 		}
 		var m int
 		m, err = strconv.Atoi(args[5])
-		var month time.Month = time.Month(m)
 		if err != nil {
 			_ = updateTransactionStatus(stub, transactionID, "Error invalid Expiration date")
 			return nil, nil
@@ -507,13 +745,22 @@ func (t *SimpleChaincode) respondToQuote(stub shim.ChaincodeStubInterface, args
 			_ = updateTransactionStatus(stub, transactionID, "Error invalid Expiration date")
 			return nil, nil
 		}
-		settlementDate := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+		settlementDate, err := strictDate(year, m, day)
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
+		}
 		
 		// check if settlement date is greater than current date
-		if settlementDate.Before(time.Now()) {
+		now, err := txTime(stub)
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
+		}
+		if settlementDate.Before(now) {
 			_ = updateTransactionStatus(stub, transactionID, "Error cannot respond to quote due to incorrect Expiration date")
+			return nil, nil
 		}
-
 		
 		t := Transaction {
 		TransactionID: transactionID,
@@ -524,11 +771,36 @@ func (t *SimpleChaincode) respondToQuote(stub shim.ChaincodeStubInterface, args
 		BankID: args[7] ,//x509Cert.Subject.CommonName,											// enrollmentID
 		StockSymbol: rfq.StockSymbol,													// get from rfq
 		Quantity:	rfq.Quantity,														// get from rfq
+		Multiplier: rfq.Multiplier,														// get from rfq
 		OptionPrice: price,																// based on input
 		StockRate: rate,																// based on input
-		SettlementDate: time.Date(year, month, day, 0, 0, 0, 0, time.UTC),				// based on input
+		SettlementDate: settlementDate,													// based on input
+		Barrier: rfq.Barrier,															// get from rfq
+		Path: rfq.Path,																	// get from rfq
+		Basket: rfq.Basket,																// get from rfq
+		Swap: rfq.Swap,																	// get from rfq
+		Timestamp: now,
+		ActorID: args[7],
 		Status: "Success",
 		}
+		// the product checks the quote against its rules, e.g. the expiry calendar and sanity bands of options
+		product, err := productFor(rfq.OptionType)
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
+		}
+		err = product.ValidateQuote(stub, &t)
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
+		}
+		
+		// add trade to bank's trade history, only once the quote is accepted
+		err = updateTradeHistory(stub, t.BankID, tradeID)
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, "Error while updating trade history")
+			return nil, nil
+		}
 
 		// convert to JSON
 		b, err := json.Marshal(t)
@@ -544,12 +816,25 @@ func (t *SimpleChaincode) respondToQuote(stub shim.ChaincodeStubInterface, args
 			_ = updateTransactionStatus(stub, transactionID, "Error while marshalling transaction data")
 			return nil, nil
 		}
-		
+
 		err = stub.PutState("currentTransactionNum", []byte(strconv.Itoa(tid)))
 		if err != nil {
 			_ = updateTransactionStatus(stub, transactionID, "Error while writing current Transaction Number to ledger")
 			return nil, nil
 		}
+
+		// index quote by bank
+		err = putIndexEntry(stub, nil, bankQuoteIndex, t.BankID, t.TradeID, t.TransactionID)
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
+		}
+		// rfq no longer awaits this bank's response
+		err = delIndexEntry(stub, inboxIndex, t.BankID, t.TradeID)
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
+		}
 		
 		// updating trade transaction history ans status
 		err = updateTradeState(stub, t.TradeID, t.TransactionID,"Responded")
@@ -557,15 +842,24 @@ func (t *SimpleChaincode) respondToQuote(stub shim.ChaincodeStubInterface, args
 			_ = updateTransactionStatus(stub, transactionID, "Error while updating trade state")
 			return nil, nil
 		}
+		err = emitTradeEvent(stub, newTradeEvent(t, "Responded"))
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
+		}
 		return nil, nil
 	}
 	return nil, errors.New("Incorrect number of arguments")
 }
 /*			arg 0	:	TradeID
 			arg 1	:	Selected quote's TransactionID
+		TradeID may be a StrategyID with the QuoteID of a strategy quote, see executeStrategy
 */
 //---------------------------------------------------------- consensus
 func (t *SimpleChaincode) tradeExec(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args)== 3 && isStrategyID(args[0]) {
+		return t.executeStrategy(stub, args)
+	}
 	if len(args)== 3 {
 		
 		ctidByte, err := stub.GetState("currentTransactionNum")
@@ -612,9 +906,18 @@ func (t *SimpleChaincode) tradeExec(stub shim.ChaincodeStubInterface, args []str
 			_ = updateTransactionStatus(stub, transactionID, "Error due to mismatch in tradeIDs")	
 			return nil, nil
 		}
+		if quote.StrategyID != "" {
+			_ = updateTransactionStatus(stub, transactionID, "Error trade "+tradeID+" is a leg of "+quote.StrategyID+", execute the strategy")
+			return nil, nil
+		}
 		
 		// check if settlement Date is greater than current date
-		if quote.SettlementDate.Before(time.Now()) {
+		now, err := txTime(stub)
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
+		}
+		if quote.SettlementDate.Before(now) {
 			_ = updateTransactionStatus(stub, transactionID, "Error cannot execute trade due to invalid Expiration date")
 			return nil, nil
 		}
@@ -628,12 +931,57 @@ func (t *SimpleChaincode) tradeExec(stub shim.ChaincodeStubInterface, args []str
 		BankID: quote.BankID,						// get from quote transaction
 		StockSymbol: quote.StockSymbol,				// get from quote transaction
 		Quantity:	quote.Quantity,					// get from quote transaction
+		Multiplier: quote.Multiplier,				// get from quote transaction
 		OptionPrice: quote.OptionPrice,				// get from quote transaction
 		StockRate: quote.StockRate,					// get from quote transaction
 		SettlementDate: quote.SettlementDate,		// get from quote transaction
+		Barrier: quote.Barrier,						// get from quote transaction
+		Path: quote.Path,							// get from quote transaction
+		Basket: quote.Basket,						// get from quote transaction
+		Swap: quote.Swap,							// get from quote transaction
+		Timestamp: now,
+		ActorID: args[2],
 		Status: "Success",
 		}
 
+		err = checkUnderlyingHalts(stub, t, t.ClientID, t.BankID)
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
+		}
+		product, err := productFor(t.OptionType)
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
+		}
+		err = product.Execute(stub, &t)
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
+		}
+		
+		// the quote was made on terms a corporate action has since changed
+		err = checkUnderlyingCorporateActions(stub, quote, quote.Timestamp)
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
+		}
+
+		// check position limits of both counterparties
+		counterparties := []string{t.ClientID, t.BankID}
+		for i := 0; i < len(counterparties); i++ {
+			party, err := getEntity(stub, counterparties[i])
+			if err != nil {
+				_ = updateTransactionStatus(stub, transactionID, err.Error())
+				return nil, nil
+			}
+			err = checkUnderlyingLimits(stub, party, t, t.StockRate*float64(shares(t.Quantity, t.Multiplier)), "Execute")
+			if err != nil {
+				_ = updateTransactionStatus(stub, transactionID, err.Error())
+				return nil, nil
+			}
+		}
+
 		// convert to JSON
 		b, err := json.Marshal(t)
 		
@@ -668,9 +1016,14 @@ func (t *SimpleChaincode) tradeExec(stub shim.ChaincodeStubInterface, args []str
 			return nil, nil
 		}
 		
-		newOption := Option{Symbol: t.StockSymbol,Quantity: t.Quantity,OptionType: t.OptionType ,StockRate: t.StockRate ,SettlementDate: t.SettlementDate,OptionPrice: t.OptionPrice, EntityID: t.BankID, TradeID:t.TradeID}
+		newOption := Option{Symbol: t.StockSymbol,Quantity: t.Quantity,Multiplier: t.Multiplier,OptionType: t.OptionType ,StockRate: t.StockRate ,SettlementDate: t.SettlementDate,OptionPrice: t.OptionPrice, EntityID: t.BankID, TradeID:t.TradeID, Barrier: t.Barrier, Path: t.Path, Basket: t.Basket, Swap: t.Swap}
 		client.Options = append(client.Options,newOption)
 		
+		err = recordPositionChanges(stub, client, t.TransactionID, t.TradeID)
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
+		}
 		b, err = json.Marshal(client)
 		if err == nil {
 			err = stub.PutState(client.EntityID,b)
@@ -692,9 +1045,14 @@ func (t *SimpleChaincode) tradeExec(stub shim.ChaincodeStubInterface, args []str
 			_ = updateTransactionStatus(stub, transactionID, "Error while unmarshalling bank data")
 			return nil, nil
 		}
-		newOption = Option{Symbol: t.StockSymbol,Quantity: t.Quantity,OptionType: bankOptionType ,StockRate: t.StockRate ,SettlementDate: t.SettlementDate,OptionPrice: t.OptionPrice, EntityID: t.ClientID, TradeID:t.TradeID}
+		newOption = Option{Symbol: t.StockSymbol,Quantity: t.Quantity,Multiplier: t.Multiplier,OptionType: bankOptionType ,StockRate: t.StockRate ,SettlementDate: t.SettlementDate,OptionPrice: t.OptionPrice, EntityID: t.ClientID, TradeID:t.TradeID, Barrier: t.Barrier, Path: t.Path, Basket: t.Basket, Swap: t.Swap}
 		bank.Options = append(bank.Options,newOption)
 		
+		err = recordPositionChanges(stub, bank, t.TransactionID, t.TradeID)
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
+		}
 		b, err = json.Marshal(bank)
 		if err == nil {
 			err = stub.PutState(bank.EntityID,b)
@@ -709,14 +1067,48 @@ func (t *SimpleChaincode) tradeExec(stub shim.ChaincodeStubInterface, args []str
 			_ = updateTransactionStatus(stub, transactionID, "Error while updating trade state")
 			return nil, nil
 		}
+		err = updateTradeBank(stub, t.TradeID, t.BankID)
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, "Error while updating trade state")
+			return nil, nil
+		}
+		// executed rfq is closed for all banks
+		err = removeFromInboxes(stub, t.TradeID)
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
+		}
+		// published prices are checked against the barrier until it is hit
+		if t.Barrier != nil {
+			err = putIndexEntry(stub, nil, liveBarrierIndex, symbolIndexValue(t.StockSymbol), t.TradeID)
+			if err != nil {
+				_ = updateTransactionStatus(stub, transactionID, err.Error())
+				return nil, nil
+			}
+		}
+		// flag suspicious executions for the regulatory body
+		err = surveilExecution(stub, t)
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
+		}
+		err = emitTradeEvent(stub, newTradeEvent(t, "Trade Executed"))
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
+		}
 		return nil, nil
 	}
 	return nil, errors.New("Incorrect number of arguments")
 }
 /*			arg 0	:	TradeID
 			arg 1	:	Yes/ No
+		forwards and swaps are settled at maturity instead, see settleContract
 */
 func (t *SimpleChaincode) tradeSet(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args)== 3 && isLinearTrade(stub, args[0]) {
+		return t.settleContract(stub, args)
+	}
 	if len(args)== 3 {
 		tradeID := args[0]
 		//tExecId := args[1]
@@ -744,32 +1136,7 @@ func (t *SimpleChaincode) tradeSet(stub shim.ChaincodeStubInterface, args []stri
 			_ = updateTransactionStatus(stub, transactionID, "Error while parsing caller certificate")
 			return nil, nil
 		}
-		clientID := args[2] //x509Cert.Subject.CommonName
-		
-		// update client entity's options
-		clientbyte,err := stub.GetState(clientID)																												
-		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while getting client info from ledger")
-			return nil, nil
-		}
-		var client Entity
-		err = json.Unmarshal(clientbyte, &client)		
-		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while unmarshalling client data")
-			return nil, nil
-		}
-		// remove option from clients data, check tradeID
-		copyFlag := false
-		for i := 0; i< len(client.Options); i++ {
-			if client.Options[i].TradeID == tradeID {
-				copyFlag = true
-				continue
-			}
-			if copyFlag == true {
-				client.Options[i-1]=client.Options[i]
-			}
-		}
-		client.Options = client.Options[:(len(client.Options)-1)]
+		holderID := args[2] //x509Cert.Subject.CommonName
 		
 		// get transactionID from tradeID
 		tradebyte,err := stub.GetState(tradeID)
@@ -783,6 +1150,11 @@ func (t *SimpleChaincode) tradeSet(stub shim.ChaincodeStubInterface, args []stri
 			_ = updateTransactionStatus(stub, transactionID, "Error while unmarshalling trade data")
 			return nil, nil
 		}
+		// only executed trades hold an option, knocked out, exercised and closed ones do not
+		if trade.Status != "Trade Executed" {
+			_ = updateTransactionStatus(stub, transactionID, "Error trade "+tradeID+" is not open, its status is "+trade.Status)
+			return nil, nil
+		}
 		tExecId := trade.TransactionHistory[len(trade.TransactionHistory)-1]
 		
 		// get information from trade exec transaction
@@ -799,6 +1171,38 @@ func (t *SimpleChaincode) tradeSet(stub shim.ChaincodeStubInterface, args []stri
 			return nil, nil
 		}
 		
+		// only the holder exercises or cancels, the bank for a leg the client sold
+		if (tExec.Side == sellSide && holderID != tExec.BankID) || (tExec.Side != sellSide && holderID != tExec.ClientID) {
+			_ = updateTransactionStatus(stub, transactionID, "Error "+holderID+" does not hold the option of "+tradeID)
+			return nil, nil
+		}
+		
+		// update client entity's options
+		clientbyte,err := stub.GetState(tExec.ClientID)																												
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, "Error while getting client info from ledger")
+			return nil, nil
+		}
+		var client Entity
+		err = json.Unmarshal(clientbyte, &client)		
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, "Error while unmarshalling client data")
+			return nil, nil
+		}
+		// remove option from clients data, check tradeID
+		for i := 0; i< len(client.Options); i++ {
+			if client.Options[i].TradeID == tradeID {
+				client.Options = append(client.Options[:i], client.Options[i+1:]...)
+				break
+			}
+		}
+		
+		err = checkUnderlyingHalts(stub, tExec, tExec.ClientID, tExec.BankID)
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
+		}
+		
 		// update bank entity's options
 		bankbyte,err := stub.GetState(tExec.BankID)																											
 		if err != nil {
@@ -812,17 +1216,20 @@ func (t *SimpleChaincode) tradeSet(stub shim.ChaincodeStubInterface, args []stri
 			return nil, nil
 		}
 		// remove option from bank 
-		copyFlag = false
 		for i := 0; i< len(bank.Options); i++ {
 			if bank.Options[i].TradeID == tradeID {
-				copyFlag = true
-				continue
-			}
-			if copyFlag == true {
-				bank.Options[i-1]=bank.Options[i]
+				bank.Options = append(bank.Options[:i], bank.Options[i+1:]...)
+				break
 			}
 		}
-		bank.Options = bank.Options[:(len(bank.Options)-1)]
+		
+		now, err := txTime(stub)
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
+		}
+		// event describing the transition, set by the branch taken below
+		var event TradeEvent
 		// check if trade has to be settled
 		if strings.ToLower(args[1]) == "yes" {
 			if tExec.TradeID != tradeID {
@@ -831,22 +1238,61 @@ func (t *SimpleChaincode) tradeSet(stub shim.ChaincodeStubInterface, args []stri
 			}
 			
 			// check settlement date to see if option is still valid
-			if time.Now().Before(tExec.SettlementDate) {
+			if now.Before(tExec.SettlementDate) {
 				
+				product, err := productFor(tExec.OptionType)
+				if err != nil {
+					_ = updateTransactionStatus(stub, transactionID, err.Error())
+					return nil, nil
+				}
+				
+				// stock can only be delivered for registered instruments, on the settlement cycle of their market
+				calendar, err := underlyingCalendar(stub, tExec)
+				if err != nil {
+					_ = updateTransactionStatus(stub, transactionID, err.Error())
+					return nil, nil
+				}
+				deliveryDate, err := calendar.addBusinessDays(now, calendar.SettlementDays)
+				if err != nil {
+					_ = updateTransactionStatus(stub, transactionID, err.Error())
+					return nil, nil
+				}
+				
+				spot := 0.0
+				m, err := underlyingMarketData(stub, tExec.StockSymbol, tExec.Basket)
+				if err == nil {
+					spot = m.Spot
+				}
 				t := Transaction{
 				TransactionID: transactionID,
 				TradeID: tradeID,							// based on input
+				StrategyID: tExec.StrategyID,				// get from tradeExec transaction
 				TransactionType: "Exercise",
 				OptionType: tExec.OptionType,				// get from tradeExec transaction
-				ClientID: args[2] , //x509Cert.Subject.CommonName,		// get from tradeExec transaction
+				Side: tExec.Side,							// get from tradeExec transaction
+				ClientID: tExec.ClientID,					// get from tradeExec transaction
 				BankID: tExec.BankID,						// get from tradeExec transaction
 				StockSymbol: tExec.StockSymbol,				// get from tradeExec transaction
 				Quantity:	tExec.Quantity,					// get from tradeExec transaction
+				Multiplier: tExec.Multiplier,				// get from tradeExec transaction
 				OptionPrice: tExec.OptionPrice,				// get from tradeExec transaction
 				StockRate: tExec.StockRate,					// get from tradeExec transaction
 				SettlementDate: tExec.SettlementDate,		// get from tradeExec transaction
+				Barrier: tExec.Barrier,						// get from tradeExec transaction
+				Path: tExec.Path,							// get from tradeExec transaction
+				Basket: tExec.Basket,						// get from tradeExec transaction
+				SpotPrice: spot,							// get from market data
+				DeliveryDate: deliveryDate,
+				Timestamp: now,
+				ActorID: args[2],
 				Status: "Success",
 				}
+				// the product delivers the shares or sets the cash owed to the holder
+				err = product.Settle(stub, &t, &client, &bank)
+				if err != nil {
+					_ = updateTransactionStatus(stub, transactionID, err.Error())
+					return nil, nil
+				}
 				// convert to JSON
 				b, err := json.Marshal(t)
 				// write to ledger
@@ -861,93 +1307,65 @@ func (t *SimpleChaincode) tradeSet(stub shim.ChaincodeStubInterface, args []stri
 					return nil, nil
 				}
 				
-				// add stock to clients portfolio, check if stock already exists if yes increase quantity else create new stock entry 		
-				stockExistFlag := false
-				for i := 0; i< len(client.Portfolio); i++ {
-					if client.Portfolio[i].Symbol == t.StockSymbol {
-						stockExistFlag = true
-						if strings.ToLower(t.OptionType) == "call" {
-							client.Portfolio[i].Quantity = client.Portfolio[i].Quantity + t.Quantity
-						} else {	// Put option type
-							if client.Portfolio[i].Quantity >= t.Quantity {
-								client.Portfolio[i].Quantity = client.Portfolio[i].Quantity - t.Quantity
-							} else {
-								_ = updateTransactionStatus(stub, transactionID, "Error insufficient stock quantity to complete the transaction")
-								return nil, nil
-							}
-						}
-						break
-					}
-				}
-				
-				if (strings.ToLower(t.OptionType) == "put") && (stockExistFlag == false) {
-					_ = updateTransactionStatus(stub, transactionID, "Error insufficient stock quantity to complete the transaction")
-					return nil, nil
-				}
-				
-				// create new stock entry
-				if stockExistFlag == false {
-					newStock := Stock{Symbol: t.StockSymbol,Quantity: t.Quantity}
-					client.Portfolio = append(client.Portfolio,newStock)
-				}
-				// update banks stock data
-				stockExistFlag = false
-				for i := 0; i< len(bank.Portfolio); i++ {
-					if bank.Portfolio[i].Symbol == t.StockSymbol {
-						stockExistFlag = true
-						if strings.ToLower(t.OptionType) == "call" {
-								if bank.Portfolio[i].Quantity >= t.Quantity {
-									bank.Portfolio[i].Quantity = bank.Portfolio[i].Quantity - t.Quantity
-								} else {
-									_ = updateTransactionStatus(stub, transactionID, "Error insufficient stock quantity to complete the transaction")
-									return nil, nil
-								}
-						} else {
-							bank.Portfolio[i].Quantity = bank.Portfolio[i].Quantity + t.Quantity
-						}
-						break
-					}
-				}
-				
-				if (strings.ToLower(t.OptionType) == "call") && (stockExistFlag == false) {
-					_ = updateTransactionStatus(stub, transactionID, "Error insufficient stock quantity to complete the transaction")
-					return nil, nil
-				}
-				
-				// create new stock entry
-				if  (strings.ToLower(t.OptionType) == "put") && (stockExistFlag == false) {
-					newStock := Stock{Symbol: t.StockSymbol,Quantity: t.Quantity}
-					bank.Portfolio = append(bank.Portfolio,newStock)
-				}				
-				
 				// updating trade state
 				err = updateTradeState(stub, t.TradeID, t.TransactionID,"Trade Exercised")
 				if err != nil {
 					_ = updateTransactionStatus(stub, transactionID, "Error while updating trade state")
 					return nil, nil
 				}
+				event = newTradeEvent(t, "Trade Exercised")
 				
 			} else {	// trade expired
 				
-				
-				_ = updateTransactionStatus(stub, transactionID, "")
+				t := closingTransaction(tExec, transactionID, "Expire", args[2], now)
+				err = writeTransaction(stub, t)
+				if err != nil {
+					_ = updateTransactionStatus(stub, transactionID, err.Error())
+					return nil, nil
+				}
 				
 				// updating trade state
-				err = updateTradeState(stub, tradeID,"" ,"Trade Expired")
+				err = updateTradeState(stub, tradeID, t.TransactionID, "Trade Expired")
 				if err != nil {
 					_ = updateTransactionStatus(stub, transactionID, "Error while updating trade state")
 					return nil, nil
 				}
+				event = newTradeEvent(t, "Trade Expired")
 				
 			}
 		} else {	// trade cancelled
-			_ = updateTransactionStatus(stub, transactionID, "")
+			t := closingTransaction(tExec, transactionID, "Cancel", args[2], now)
+			err = writeTransaction(stub, t)
+			if err != nil {
+				_ = updateTransactionStatus(stub, transactionID, err.Error())
+				return nil, nil
+			}
 			// updating trade state
-			err = updateTradeState(stub, tradeID,"" ,"Trade Cancelled")
+			err = updateTradeState(stub, tradeID, t.TransactionID, "Trade Cancelled")
 			if err != nil {
 				_ = updateTransactionStatus(stub, transactionID, "Error while updating trade state")
 				return nil, nil
 			}
+			event = newTradeEvent(t, "Trade Cancelled")
+		}
+		// a closed trade is no longer monitored
+		if tExec.Barrier != nil {
+			err = delIndexEntry(stub, liveBarrierIndex, symbolIndexValue(tExec.StockSymbol), tradeID)
+			if err != nil {
+				_ = updateTransactionStatus(stub, transactionID, err.Error())
+				return nil, nil
+			}
+		}
+		// record position movements of client and bank
+		err = recordPositionChanges(stub, client, transactionID, tradeID)
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
+		}
+		err = recordPositionChanges(stub, bank, transactionID, tradeID)
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
 		}
 		// update client state
 		b, err := json.Marshal(client)
@@ -971,6 +1389,119 @@ func (t *SimpleChaincode) tradeSet(stub shim.ChaincodeStubInterface, args []stri
 			_ = updateTransactionStatus(stub, transactionID, "Error while writing currentTransactionNum to ledger")
 			return nil, nil
 		}
+		err = emitTradeEvent(stub, event)
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
+		}
+		return nil, nil
+	}
+	return nil, errors.New("Incorrect number of arguments")
+}
+// used by client to withdraw a quote request that has not been executed yet
+/*			arg 0	:	TradeID
+			arg 1	:	ClientID
+		TradeID may be a StrategyID to withdraw all legs, see cancelStrategy
+*/
+func (t *SimpleChaincode) cancelQuoteRequest(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args)== 2 && isStrategyID(args[0]) {
+		return t.cancelStrategy(stub, args)
+	}
+	if len(args)== 2 {
+		tradeID := args[0]
+
+		ctidByte, err := stub.GetState("currentTransactionNum")
+		if(err != nil){
+			return nil, errors.New("Error while getting currentTransactionNum from ledger")
+		}
+		tid,err := strconv.Atoi(string(ctidByte))
+		if(err != nil){
+			return nil, errors.New("Error while converting ctidByte to integer")
+		}
+		tid = tid + 1
+		transactionID := "trans"+strconv.Itoa(tid)
+
+		tradebyte,err := stub.GetState(tradeID)
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, "Error while getting trade info from ledger")
+			return nil, nil
+		}
+		var trade Trade
+		err = json.Unmarshal(tradebyte, &trade)
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, "Error while unmarshalling trade data")
+			return nil, nil
+		}
+		if trade.ClientID != args[1] {
+			_ = updateTransactionStatus(stub, transactionID, "Error only the requesting client can cancel a quote request")
+			return nil, nil
+		}
+		if trade.StrategyID != "" {
+			_ = updateTransactionStatus(stub, transactionID, "Error trade "+tradeID+" is a leg of "+trade.StrategyID+", cancel the strategy")
+			return nil, nil
+		}
+		if trade.Status != "Quote requested" && trade.Status != "Responded" {
+			_ = updateTransactionStatus(stub, transactionID, "Error cannot cancel quote request of a trade in status "+trade.Status)
+			return nil, nil
+		}
+		now, err := txTime(stub)
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
+		}
+
+		t := Transaction{
+		TransactionID: transactionID,
+		TradeID: tradeID,								// based on input
+		TransactionType: "Cancel",
+		OptionType: trade.TradeType,					// get from trade
+		ClientID: args[1],								// based on input
+		StockSymbol: trade.Symbol,						// get from trade
+		Quantity: trade.Quantity,						// get from trade
+		Timestamp: now,
+		ActorID: args[1],
+		Status: "Success",
+		}
+		b, err := json.Marshal(t)
+		if err == nil {
+			err = stub.PutState(t.TransactionID,b)
+			if err != nil {
+				_ = updateTransactionStatus(stub, transactionID, "Error while writing Cancel transaction to ledger")
+				return nil, nil
+			}
+		} else {
+			_ = updateTransactionStatus(stub, transactionID, "Error while marshalling transaction data")
+			return nil, nil
+		}
+
+		err = stub.PutState("currentTransactionNum", []byte(strconv.Itoa(tid)))
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, "Error while writing currentTransactionNum to ledger")
+			return nil, nil
+		}
+
+		// withdrawn rfq is closed for all banks
+		err = removeFromInboxes(stub, tradeID)
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
+		}
+		err = surveilWithdrawal(stub, t)
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
+		}
+
+		err = updateTradeState(stub, tradeID, t.TransactionID, "Quote Cancelled")
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, "Error while updating trade state")
+			return nil, nil
+		}
+		err = emitTradeEvent(stub, newTradeEvent(t, "Quote Cancelled"))
+		if err != nil {
+			_ = updateTransactionStatus(stub, transactionID, err.Error())
+			return nil, nil
+		}
 		return nil, nil
 	}
 	return nil, errors.New("Incorrect number of arguments")
@@ -1043,6 +1574,19 @@ func updateTradeHistory(stub shim.ChaincodeStubInterface, entityID string, trade
 	return nil
 }
 
+func getEntity(stub shim.ChaincodeStubInterface, entityID string) (Entity, error) {
+	var entity Entity
+	entitybyte,err := stub.GetState(entityID)
+	if err != nil {
+		return entity, errors.New("Error while getting entity info from ledger")
+	}
+	err = json.Unmarshal(entitybyte, &entity)
+	if err != nil {
+		return entity, errors.New("Error while unmarshalling entity data")
+	}
+	return entity, nil
+}
+
 func updateTradeState(stub shim.ChaincodeStubInterface, tradeID string, transactionID string, status string) (error) {
 	// read trade state
 	tradebyte,err := stub.GetState(tradeID)																										
@@ -1056,7 +1600,19 @@ func updateTradeState(stub shim.ChaincodeStubInterface, tradeID string, transact
 	}
 	// add transactionID to history
 	trade.TransactionHistory = append(trade.TransactionHistory,transactionID)
-	
+
+	// move trade to the new status in the status index
+	if trade.Status != "" {
+		err = delIndexEntry(stub, statusIndex, statusIndexValue(trade.Status), trade.TradeID)
+		if err != nil {
+			return err
+		}
+	}
+	err = putIndexEntry(stub, nil, statusIndex, statusIndexValue(status), trade.TradeID)
+	if err != nil {
+		return err
+	}
+
 	// update status
 	trade.Status = status
 	
@@ -1070,6 +1626,27 @@ func updateTradeState(stub shim.ChaincodeStubInterface, tradeID string, transact
 	return nil
 }
 
+// record the bank a trade was executed with
+func updateTradeBank(stub shim.ChaincodeStubInterface, tradeID string, bankID string) (error) {
+	tradebyte,err := stub.GetState(tradeID)
+	if err != nil {
+		return errors.New("Error while getting trade info from ledger")
+	}
+	var trade Trade
+	err = json.Unmarshal(tradebyte, &trade)
+	if err != nil {
+		return errors.New("Error while unmarshalling trade data")
+	}
+	trade.BankID = bankID
+	b, err := json.Marshal(trade)
+	if err == nil {
+		err = stub.PutState(trade.TradeID,b)
+	} else {
+		return errors.New("Error while updating trade status")
+	}
+	return nil
+}
+
 func (t *SimpleChaincode) trial(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	return nil, errors.New("********* TRIAL ERROR *********")
 }
@@ -1119,66 +1696,84 @@ func (t *SimpleChaincode) readTrades(stub shim.ChaincodeStubInterface, args []st
 }
 func (t *SimpleChaincode) readQuoteRequests(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	var quoteTransactions []string
-	// get current Trade number
-	ctidByte, err := stub.GetState("currentTradeNum")
-	if(err != nil){
-		return nil, errors.New("Error while getting currentTradeNum from ledger")
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments")
 	}
-	tradeNum,err := strconv.Atoi(string(ctidByte))
-	if(err != nil){
-		return nil, errors.New("Error while converting ctidByte to integer")
+	currentUserID := args[0] //x509Cert.Subject.CommonName
+	// rfqs awaiting the bank's response
+	entries,err := scanIndex(stub, inboxIndex, currentUserID)
+	if err != nil {
+		return nil, err
 	}
-	// check all trades
-	for tradeNum > 1000 {
-		// read trade state
-		tradebyte,err := stub.GetState("trade"+strconv.Itoa(tradeNum))
+	quoteIDs := make(map[string]string)
+	tradeIDs := make([]string, len(entries))
+	for i:=0; i< len(entries); i++ {
+		tradeIDs[i] = entries[i].Attrs[0]
+		quoteIDs[tradeIDs[i]] = string(entries[i].Value)
+	}
+	sort.Sort(byTradeNumDesc(tradeIDs))
+	for i:=0; i< len(tradeIDs); i++ {
+		// leave out rfqs that can no longer be answered
+		open,err := rfqOpen(stub, tradeIDs[i], quoteIDs[tradeIDs[i]])
 		if err != nil {
-			return nil, errors.New("Error while getting trade info from ledger")
+			return nil, err
 		}
-		var trade Trade
-		err = json.Unmarshal(tradebyte, &trade)		
-		if err != nil {
-			return nil, errors.New("Error while unmarshalling trade data")
-		}
-		// check status
-		fmt.Print("Trade Status "+trade.Status)
-		if trade.Status == "Quote requested" {
-			quoteTransactions = append(quoteTransactions,trade.TransactionHistory[0])
-		} else if trade.Status == "Responded" { // check who has responded
-			respondedFlag := false
-			bytes, _ := stub.GetCallerCertificate()
-			fmt.Print(string(bytes))
-			//x509Cert, _ := x509.ParseCertificate(bytes)
-			currentUserID := args[0] //x509Cert.Subject.CommonName
-			
-			for i:=0; i< len(trade.TransactionHistory); i++ {
-				tranbyte,err := stub.GetState(trade.TransactionHistory[i])
-				if(err != nil){
-					return nil, errors.New("Error while getting transaction from ledger")
-				}
-				var tran Transaction
-				err = json.Unmarshal(tranbyte, &tran)		
-				if(err != nil){
-					return nil, errors.New("Error while unmarshalling tran data")
-				}
-				if tran.TransactionType == "Response" {
-					if tran.BankID == currentUserID {
-						respondedFlag = true
-						break
-					}
-				}
-			}
-			if respondedFlag == false {
-				quoteTransactions = append(quoteTransactions,trade.TransactionHistory[0])
-			}
+		if open {
+			quoteTransactions = append(quoteTransactions,quoteIDs[tradeIDs[i]])
 		}
-		tradeNum--
 	}
 	b, err := json.Marshal(quoteTransactions)
 	fmt.Print("Trade List"+string(b))
 	return b, nil
 }
 
+// transaction closing an executed trade without exercise, at the time of the closing invoke
+func closingTransaction(tExec Transaction, transactionID string, transactionType string, actorID string, now time.Time) Transaction {
+		return Transaction{
+		TransactionID: transactionID,
+		TradeID: tExec.TradeID,
+		StrategyID: tExec.StrategyID,				// get from tradeExec transaction
+		TransactionType: transactionType,			// Expire, Cancel or Settle
+		OptionType: tExec.OptionType,				// get from tradeExec transaction
+		Side: tExec.Side,							// get from tradeExec transaction
+		ClientID: tExec.ClientID,					// get from tradeExec transaction
+		BankID: tExec.BankID,						// get from tradeExec transaction
+		StockSymbol: tExec.StockSymbol,				// get from tradeExec transaction
+		Quantity: tExec.Quantity,					// get from tradeExec transaction
+		Multiplier: tExec.Multiplier,				// get from tradeExec transaction
+		OptionPrice: tExec.OptionPrice,				// get from tradeExec transaction
+		StockRate: tExec.StockRate,					// get from tradeExec transaction
+		SettlementDate: tExec.SettlementDate,		// get from tradeExec transaction
+		Barrier: tExec.Barrier,						// get from tradeExec transaction
+		Path: tExec.Path,							// get from tradeExec transaction
+		Basket: tExec.Basket,						// get from tradeExec transaction
+		Swap: tExec.Swap,							// get from tradeExec transaction
+		Timestamp: now,
+		ActorID: actorID,
+		Status: "Success",
+		}
+}
+func writeTransaction(stub shim.ChaincodeStubInterface, t Transaction) (error) {
+		b, err := json.Marshal(t)
+		if err != nil {
+			return errors.New("Error while marshalling transaction data")
+		}
+		err = stub.PutState(t.TransactionID,b)
+		if err != nil {
+			return errors.New("Error while writing Transaction to ledger")
+		}
+		return nil
+}
+// timestamp of the current transaction, the same on every peer, used instead of the local clock for
+// anything written to the ledger or deciding whether a transaction is accepted
+func txTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil || ts == nil {
+		return time.Time{}, errors.New("Error while getting transaction timestamp")
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
+
 func updateTransactionStatus(stub shim.ChaincodeStubInterface, transactionID string, status string) (error) {
 		//Transaction
 		t := Transaction{
@@ -1291,3 +1886,92 @@ func (t *SimpleChaincode) getTransactionStatus(stub shim.ChaincodeStubInterface,
 		}
 		return nil, errors.New("Incorrect number of arguments")
 }
+// reference price for an option so that bank quotes can be benchmarked
+/*			arg 0	:	StockSymbol
+			arg 1	:	OptionType
+			arg 2	:	StockRate
+			arg 3	:	SettlementDate Year
+			arg 4	:	SettlementDate Month
+			arg 5	:	SettlementDate Day
+			arg 6	:	Spot price (empty to use published market data)
+			arg 7	:	Volatility (empty to use published market data)
+			arg 8	:	Risk free rate
+			arg 9	:	European/ American (optional, defaults to European)
+*/
+func (t *SimpleChaincode) priceOption(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 9 && len(args) != 10 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	strike, err := strconv.ParseFloat(args[2], 64)
+	if err != nil {
+		return nil, errors.New("Error invalid stock rate")
+	}
+	year, err := strconv.Atoi(args[3])
+	if err != nil {
+		return nil, errors.New("Error invalid Expiration date")
+	}
+	month, err := strconv.Atoi(args[4])
+	if err != nil {
+		return nil, errors.New("Error invalid Expiration date")
+	}
+	day, err := strconv.Atoi(args[5])
+	if err != nil {
+		return nil, errors.New("Error invalid Expiration date")
+	}
+	settlementDate, err := strictDate(year, month, day)
+	if err != nil {
+		return nil, err
+	}
+	var spot, vol float64
+	if args[6] == "" || args[7] == "" {
+		m, err := getFreshMarketData(stub, args[0])
+		if err != nil {
+			return nil, err
+		}
+		spot, vol = m.Spot, m.Volatility
+	}
+	if args[6] != "" {
+		spot, err = strconv.ParseFloat(args[6], 64)
+		if err != nil {
+			return nil, errors.New("Error invalid spot price")
+		}
+	}
+	if args[7] != "" {
+		vol, err = strconv.ParseFloat(args[7], 64)
+		if err != nil {
+			return nil, errors.New("Error invalid volatility")
+		}
+	}
+	rate, err := strconv.ParseFloat(args[8], 64)
+	if err != nil {
+		return nil, errors.New("Error invalid risk free rate")
+	}
+	style := "European"
+	if len(args) == 10 {
+		style = args[9]
+	}
+	v := OptionValuation{
+		StockSymbol: args[0],
+		OptionType: args[1],
+		ExerciseStyle: style,
+		StockRate: strike,
+		SettlementDate: settlementDate,
+		Spot: spot,
+		Volatility: vol,
+		Rate: rate,
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	v.TimeToExpiry = yearFraction(now, v.SettlementDate)
+	v.Price, err = modelPrice(v.ExerciseStyle, v.OptionType, v.Spot, v.StockRate, v.Volatility, v.Rate, v.TimeToExpiry)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.New("Error while marshalling option valuation")
+	}
+	return b, nil
+}
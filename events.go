@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// name and schema version of the event set by every trade lifecycle invoke, see README.md
+// for the payload; bump the version whenever a field is renamed, removed or changes meaning
+const tradeEventName = "TradeEvent"
+const tradeEventVersion = "1"
+
+type TradeEvent struct {
+	Version         string
+	TradeID         string
+	StrategyID      string // strategy the trade is a leg of, empty for single options
+	TransactionID   string
+	TransactionType string // Request, Response, Execute, Exercise, Expire, Cancel, KnockIn, KnockOut, Settle or Reset
+	ClientID        string
+	BankID          string // empty for Request
+	StockSymbol     string
+	Status          string // trade status after the transition
+	Timestamp       time.Time
+	Batch           []TradeEvent `json:",omitempty"` // transitions of further trades made by the same invoke
+}
+
+func newTradeEvent(tran Transaction, status string) TradeEvent {
+	return TradeEvent{
+		Version:         tradeEventVersion,
+		TradeID:         tran.TradeID,
+		StrategyID:      tran.StrategyID,
+		TransactionID:   tran.TransactionID,
+		TransactionType: tran.TransactionType,
+		ClientID:        tran.ClientID,
+		BankID:          tran.BankID,
+		StockSymbol:     tran.StockSymbol,
+		Status:          status,
+	}
+}
+
+// sets the chaincode event of the current invoke stamped with its transaction time, only the last one set is delivered
+func emitTradeEvent(stub shim.ChaincodeStubInterface, event TradeEvent) error {
+	var err error
+	event.Timestamp, err = txTime(stub)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(event.Batch); i++ {
+		event.Batch[i].Timestamp = event.Timestamp
+	}
+	b, err := json.Marshal(event)
+	if err != nil {
+		return errors.New("Error while marshalling trade event")
+	}
+	err = stub.SetEvent(tradeEventName, b)
+	if err != nil {
+		return errors.New("Error while setting trade event")
+	}
+	return nil
+}
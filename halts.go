@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const activeHaltIndex = "halt" // scope, target -> haltID of the halt in force
+
+// halt scopes
+const globalHalt = "Global"
+const symbolHalt = "Symbol"
+const entityHalt = "Entity"
+
+type Halt struct {
+	HaltID     string
+	Scope      string // Global, Symbol or Entity
+	Target     string // StockSymbol or EntityID, * for Global
+	Reason     string
+	HaltedBy   string
+	HaltedAt   time.Time
+	Active     bool
+	ResumedBy  string
+	ResumedAt  time.Time
+	ResumeNote string
+}
+
+// normalized scope and target of a halt
+func haltScope(scope string, target string) (string, string, error) {
+	switch strings.ToLower(scope) {
+	case "global":
+		return globalHalt, "*", nil
+	case "symbol":
+		if target == "" {
+			return "", "", errors.New("Error symbol halt needs a StockSymbol")
+		}
+		return symbolHalt, strings.ToUpper(target), nil
+	case "entity":
+		if target == "" {
+			return "", "", errors.New("Error entity freeze needs an EntityID")
+		}
+		return entityHalt, target, nil
+	}
+	return "", "", errors.New("Error invalid halt scope " + scope)
+}
+
+func readHalt(stub shim.ChaincodeStubInterface, haltID string) (Halt, error) {
+	var halt Halt
+	haltbyte, err := stub.GetState(haltID)
+	if err != nil {
+		return halt, errors.New("Error while getting halt from ledger")
+	}
+	err = json.Unmarshal(haltbyte, &halt)
+	if err != nil {
+		return halt, errors.New("Error while unmarshalling halt")
+	}
+	return halt, nil
+}
+
+func writeHalt(stub shim.ChaincodeStubInterface, halt Halt) error {
+	b, err := json.Marshal(halt)
+	if err != nil {
+		return errors.New("Error while marshalling halt")
+	}
+	err = stub.PutState(halt.HaltID, b)
+	if err != nil {
+		return errors.New("Error while writing halt to ledger")
+	}
+	return nil
+}
+
+// active halt of a scope and target, ok is false when trading is not halted
+func activeHalt(stub shim.ChaincodeStubInterface, scope string, target string) (Halt, bool, error) {
+	haltID, err := stub.GetState(indexKey(activeHaltIndex, scope, target))
+	if err != nil {
+		return Halt{}, false, errors.New("Error while getting halts from ledger")
+	}
+	if len(haltID) == 0 {
+		return Halt{}, false, nil
+	}
+	halt, err := readHalt(stub, string(haltID))
+	if err != nil {
+		return halt, false, err
+	}
+	return halt, true, nil
+}
+
+// error holding the halt reason if trading is halted globally, on the symbol or for any of the entities
+func checkHalts(stub shim.ChaincodeStubInterface, symbol string, entityIDs ...string) error {
+	scopes := [][]string{{globalHalt, "*"}, {symbolHalt, strings.ToUpper(symbol)}}
+	for i := 0; i < len(entityIDs); i++ {
+		if entityIDs[i] != "" {
+			scopes = append(scopes, []string{entityHalt, entityIDs[i]})
+		}
+	}
+	for i := 0; i < len(scopes); i++ {
+		halt, ok, err := activeHalt(stub, scopes[i][0], scopes[i][1])
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		switch halt.Scope {
+		case globalHalt:
+			return errors.New("Error trading halted: " + halt.Reason)
+		case symbolHalt:
+			return errors.New("Error trading halted on " + halt.Target + ": " + halt.Reason)
+		default:
+			return errors.New("Error entity " + halt.Target + " frozen: " + halt.Reason)
+		}
+	}
+	return nil
+}
+
+// used by the regulatory body to halt trading globally, on a symbol, or to freeze an entity
+/*			arg 0	:	Global/ Symbol/ Entity
+			arg 1	:	StockSymbol or EntityID, ignored for Global
+			arg 2	:	Reason
+			arg 3	:	RegBody EntityID
+*/
+func (t *SimpleChaincode) haltTrading(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	err := requireRegBody(stub, args[3], "halt trading")
+	if err != nil {
+		return nil, err
+	}
+	scope, target, err := haltScope(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	if scope == entityHalt {
+		_, err = getEntity(stub, target)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if args[2] == "" {
+		return nil, errors.New("Error halt needs a reason")
+	}
+	_, ok, err := activeHalt(stub, scope, target)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return nil, errors.New("Error " + strings.ToLower(scope) + " halt already in force for " + target)
+	}
+
+	num := 1000
+	numbyte, err := stub.GetState("currentHaltNum")
+	if err != nil {
+		return nil, errors.New("Error while getting currentHaltNum from ledger")
+	}
+	if len(numbyte) != 0 {
+		num, err = strconv.Atoi(string(numbyte))
+		if err != nil {
+			return nil, errors.New("Error while converting currentHaltNum to integer")
+		}
+	}
+	num++
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	halt := Halt{
+		HaltID:   "halt" + strconv.Itoa(num),
+		Scope:    scope,
+		Target:   target,
+		Reason:   args[2],
+		HaltedBy: args[3],
+		HaltedAt: now,
+		Active:   true,
+	}
+	err = writeHalt(stub, halt)
+	if err != nil {
+		return nil, err
+	}
+	err = stub.PutState("currentHaltNum", []byte(strconv.Itoa(num)))
+	if err != nil {
+		return nil, errors.New("Error while writing currentHaltNum to ledger")
+	}
+	return nil, putIndexEntry(stub, []byte(halt.HaltID), activeHaltIndex, scope, target)
+}
+
+// used by the regulatory body to lift a halt or an entity freeze
+/*			arg 0	:	Global/ Symbol/ Entity
+			arg 1	:	StockSymbol or EntityID, ignored for Global
+			arg 2	:	Note
+			arg 3	:	RegBody EntityID
+*/
+func (t *SimpleChaincode) resumeTrading(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	err := requireRegBody(stub, args[3], "resume trading")
+	if err != nil {
+		return nil, err
+	}
+	scope, target, err := haltScope(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	halt, ok, err := activeHalt(stub, scope, target)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("Error no " + strings.ToLower(scope) + " halt in force for " + target)
+	}
+	halt.Active = false
+	halt.ResumedBy = args[3]
+	halt.ResumedAt, err = txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	halt.ResumeNote = args[2]
+	err = writeHalt(stub, halt)
+	if err != nil {
+		return nil, err
+	}
+	return nil, delIndexEntry(stub, activeHaltIndex, scope, target)
+}
+
+func (t *SimpleChaincode) getActiveHalts(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 0 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	entries, err := scanIndex(stub, activeHaltIndex)
+	if err != nil {
+		return nil, err
+	}
+	halts := make([]Halt, len(entries))
+	for i := 0; i < len(entries); i++ {
+		halts[i], err = readHalt(stub, string(entries[i].Value))
+		if err != nil {
+			return nil, err
+		}
+	}
+	b, err := json.Marshal(halts)
+	if err != nil {
+		return nil, errors.New("Error while marshalling halts")
+	}
+	return b, nil
+}
+
+// all halts ever set, newest first, optionally only those of a scope and target
+/*			arg 0	:	Global/ Symbol/ Entity (optional)
+			arg 1	:	StockSymbol or EntityID (optional)
+*/
+func (t *SimpleChaincode) getHaltHistory(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) > 2 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	scope, target := "", ""
+	if len(args) == 2 {
+		target = args[1]
+	}
+	if len(args) > 0 {
+		var err error
+		scope, target, err = haltScope(args[0], target)
+		if err != nil {
+			return nil, err
+		}
+	}
+	numbyte, err := stub.GetState("currentHaltNum")
+	if err != nil {
+		return nil, errors.New("Error while getting currentHaltNum from ledger")
+	}
+	num, _ := strconv.Atoi(string(numbyte))
+	halts := []Halt{}
+	for ; num > 1000; num-- {
+		halt, err := readHalt(stub, "halt"+strconv.Itoa(num))
+		if err != nil {
+			return nil, err
+		}
+		if scope != "" && (halt.Scope != scope || halt.Target != target) {
+			continue
+		}
+		halts = append(halts, halt)
+	}
+	b, err := json.Marshal(halts)
+	if err != nil {
+		return nil, errors.New("Error while marshalling halts")
+	}
+	return b, nil
+}
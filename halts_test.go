@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHaltBlocksRequestForQuote(t *testing.T) {
+	cc := new(SimpleChaincode)
+	stub := newTestLedger(t, time.Date(2026, 3, 2, 15, 0, 0, 0, time.UTC))
+	_, err := cc.haltTrading(stub, []string{"Symbol", "AAPL", "pending news", entity1})
+	if err == nil {
+		t.Errorf("expected an error for a halt by a client")
+	}
+	_, err = cc.haltTrading(stub, []string{"Symbol", "aapl", "pending news", entity4})
+	if err != nil {
+		t.Fatalf("halt: %v", err)
+	}
+
+	quoteID := nextTransactionID(stub)
+	_, _ = cc.requestForQuote(stub, []string{"call", "AAPL", "1", entity1})
+	if got, want := transactionStatus(stub, quoteID), "Error trading halted on AAPL: pending news"; got != want {
+		t.Errorf("request on a halted symbol: got status %q, want %q", got, want)
+	}
+	if stub.state["trade1001"] != nil {
+		t.Errorf("rejected request created a trade")
+	}
+	// other symbols still trade
+	quoteID = nextTransactionID(stub)
+	_, _ = cc.requestForQuote(stub, []string{"call", "MSFT", "1", entity1})
+	if got := transactionStatus(stub, quoteID); got != "Success" {
+		t.Errorf("request on another symbol: got status %q", got)
+	}
+
+	_, err = cc.resumeTrading(stub, []string{"Symbol", "AAPL", "news out", entity4})
+	if err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	quoteID = nextTransactionID(stub)
+	_, _ = cc.requestForQuote(stub, []string{"call", "AAPL", "1", entity1})
+	if got := transactionStatus(stub, quoteID); got != "Success" {
+		t.Errorf("request after resuming: got status %q", got)
+	}
+}
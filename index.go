@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// secondary indexes kept as separate keys of the form
+//		idx \x00 <index> \x00 <attr 1> \x00 ... \x00 <attr n> \x00
+// so that all entries sharing leading attributes can be read with a single range query
+const indexKeyPrefix = "idx"
+const indexKeySeparator = "\x00"
+
+var indexKeyEnd = string(utf8.MaxRune)
+var indexEntryMarker = []byte{0x00}
+
+// index names
+const statusIndex = "status"       // status, tradeID
+const symbolIndex = "symbol"       // symbol, tradeID
+const clientIndex = "client"       // clientID, tradeID
+const bankQuoteIndex = "bankQuote" // bankID, tradeID, quote transactionID
+const inboxIndex = "inbox"         // bankID, tradeID -> rfq transactionID, rfqs awaiting the bank's response
+const basketIndex = "basket"       // component symbol, tradeID of a basket option
+
+type indexEntry struct {
+	Attrs []string
+	Value []byte
+}
+
+func indexKey(index string, attrs ...string) string {
+	key := indexKeyPrefix + indexKeySeparator + index + indexKeySeparator
+	for i := 0; i < len(attrs); i++ {
+		key += attrs[i] + indexKeySeparator
+	}
+	return key
+}
+
+func splitIndexKey(key string) []string {
+	parts := strings.Split(strings.TrimSuffix(key, indexKeySeparator), indexKeySeparator)
+	if len(parts) < 2 {
+		return nil
+	}
+	return parts[2:]
+}
+
+func putIndexEntry(stub shim.ChaincodeStubInterface, value []byte, index string, attrs ...string) error {
+	if len(value) == 0 {
+		value = indexEntryMarker
+	}
+	err := stub.PutState(indexKey(index, attrs...), value)
+	if err != nil {
+		return errors.New("Error while writing " + index + " index to ledger")
+	}
+	return nil
+}
+
+func delIndexEntry(stub shim.ChaincodeStubInterface, index string, attrs ...string) error {
+	err := stub.DelState(indexKey(index, attrs...))
+	if err != nil {
+		return errors.New("Error while deleting " + index + " index entry from ledger")
+	}
+	return nil
+}
+
+// all entries of an index whose leading attributes equal attrs
+func scanIndex(stub shim.ChaincodeStubInterface, index string, attrs ...string) ([]indexEntry, error) {
+	return scanIndexRange(stub, index, "", indexKeyEnd, attrs...)
+}
+
+// entries of an index whose leading attributes equal attrs and whose next attribute sorts from from up to,
+// not including, to
+func scanIndexRange(stub shim.ChaincodeStubInterface, index string, from string, to string, attrs ...string) ([]indexEntry, error) {
+	prefix := indexKey(index, attrs...)
+	iter, err := stub.RangeQueryState(prefix+from, prefix+to)
+	if err != nil {
+		return nil, errors.New("Error while reading " + index + " index from ledger")
+	}
+	defer iter.Close()
+	var entries []indexEntry
+	for iter.HasNext() {
+		key, value, err := iter.Next()
+		if err != nil {
+			return nil, errors.New("Error while reading " + index + " index from ledger")
+		}
+		if key >= prefix+to {
+			// range queries include the end key
+			continue
+		}
+		entries = append(entries, indexEntry{Attrs: splitIndexKey(key)[len(attrs):], Value: value})
+	}
+	return entries, nil
+}
+
+// trade IDs found at position pos of the remaining attributes of an index scan, newest first
+func tradeIDsFromIndex(stub shim.ChaincodeStubInterface, pos int, index string, attrs ...string) ([]string, error) {
+	entries, err := scanIndex(stub, index, attrs...)
+	if err != nil {
+		return nil, err
+	}
+	var tradeIDs []string
+	seen := make(map[string]bool)
+	for i := 0; i < len(entries); i++ {
+		if len(entries[i].Attrs) > pos && !seen[entries[i].Attrs[pos]] {
+			seen[entries[i].Attrs[pos]] = true
+			tradeIDs = append(tradeIDs, entries[i].Attrs[pos])
+		}
+	}
+	sort.Sort(byTradeNumDesc(tradeIDs))
+	return tradeIDs, nil
+}
+
+func statusIndexValue(status string) string {
+	return strings.ToLower(status)
+}
+
+func symbolIndexValue(symbol string) string {
+	return strings.ToUpper(symbol)
+}
+
+// trade IDs ordered newest first, "trade1010" sorts before "trade999"
+type byTradeNumDesc []string
+
+func (a byTradeNumDesc) Len() int      { return len(a) }
+func (a byTradeNumDesc) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byTradeNumDesc) Less(i, j int) bool {
+	ni, _ := strconv.Atoi(strings.TrimPrefix(a[i], "trade"))
+	nj, _ := strconv.Atoi(strings.TrimPrefix(a[j], "trade"))
+	return ni > nj
+}
+
+// writes the symbol, client, status and basket index entries of a trade
+func indexTrade(stub shim.ChaincodeStubInterface, trade Trade) error {
+	err := putIndexEntry(stub, nil, symbolIndex, symbolIndexValue(trade.Symbol), trade.TradeID)
+	if err != nil {
+		return err
+	}
+	if trade.Basket != nil {
+		for i := 0; i < len(trade.Basket.Components); i++ {
+			err = putIndexEntry(stub, nil, basketIndex, symbolIndexValue(trade.Basket.Components[i].Symbol), trade.TradeID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if trade.ClientID != "" {
+		err = putIndexEntry(stub, nil, clientIndex, trade.ClientID, trade.TradeID)
+		if err != nil {
+			return err
+		}
+	}
+	if trade.Status != "" {
+		err = putIndexEntry(stub, nil, statusIndex, statusIndexValue(trade.Status), trade.TradeID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// used by the regulatory body to index trades written before the indexes existed
+/*			arg 0	:	RegBody EntityID
+*/
+func (t *SimpleChaincode) rebuildIndexes(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if entity.EntityType != "RegBody" {
+		return nil, errors.New("Error only Regulatory Body can rebuild indexes")
+	}
+	tradeIDs, err := visibleTradeIDs(stub, entity)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(tradeIDs); i++ {
+		tradebyte, err := stub.GetState(tradeIDs[i])
+		if err != nil {
+			return nil, errors.New("Error while getting trade info from ledger")
+		}
+		if len(tradebyte) == 0 {
+			continue
+		}
+		var trade Trade
+		err = json.Unmarshal(tradebyte, &trade)
+		if err != nil {
+			return nil, errors.New("Error while unmarshalling trade data")
+		}
+		responded := make(map[string]bool)
+		for j := 0; j < len(trade.TransactionHistory); j++ {
+			if trade.TransactionHistory[j] == "" {
+				continue
+			}
+			tranbyte, err := stub.GetState(trade.TransactionHistory[j])
+			if err != nil {
+				return nil, errors.New("Error while getting transaction from ledger")
+			}
+			var tran Transaction
+			err = json.Unmarshal(tranbyte, &tran)
+			if err != nil {
+				return nil, errors.New("Error while unmarshalling transaction data")
+			}
+			if tran.TransactionType == "Request" && trade.ClientID == "" {
+				trade.ClientID = tran.ClientID
+			} else if tran.TransactionType == "Response" {
+				responded[tran.BankID] = true
+				err = putIndexEntry(stub, nil, bankQuoteIndex, tran.BankID, trade.TradeID, tran.TransactionID)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		err = indexTrade(stub, trade)
+		if err != nil {
+			return nil, err
+		}
+		if trade.Status == "Trade Executed" {
+			terms, err := currentTerms(stub, trade)
+			if err != nil {
+				return nil, err
+			}
+			if terms.Barrier != nil && terms.Barrier.Status == barrierLive {
+				err = putIndexEntry(stub, nil, liveBarrierIndex, symbolIndexValue(terms.StockSymbol), trade.TradeID)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		// open rfqs go to the inbox of every bank that has not responded yet
+		if trade.Status == "Quote requested" || trade.Status == "Responded" {
+			banks, err := bankIDs(stub)
+			if err != nil {
+				return nil, err
+			}
+			for j := 0; j < len(banks); j++ {
+				if !responded[banks[j]] {
+					err = putIndexEntry(stub, []byte(trade.TransactionHistory[0]), inboxIndex, banks[j], trade.TradeID)
+					if err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+// banks of the entity list
+func bankIDs(stub shim.ChaincodeStubInterface) ([]string, error) {
+	var allEntities []string
+	listbyte, err := stub.GetState("entityList")
+	if err != nil {
+		return nil, errors.New("Error while getting entity list from ledger")
+	}
+	err = json.Unmarshal(listbyte, &allEntities)
+	if err != nil {
+		return nil, errors.New("Error while unmarshalling entity data")
+	}
+	var banks []string
+	for i := 0; i < len(allEntities); i++ {
+		entity, err := getEntity(stub, allEntities[i])
+		if err != nil {
+			return nil, err
+		}
+		if entity.EntityType == "Bank" {
+			banks = append(banks, entity.EntityID)
+		}
+	}
+	return banks, nil
+}
+
+// puts an rfq into the inbox of every bank
+func addToInboxes(stub shim.ChaincodeStubInterface, tradeID string, quoteID string) error {
+	banks, err := bankIDs(stub)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(banks); i++ {
+		err = putIndexEntry(stub, []byte(quoteID), inboxIndex, banks[i], tradeID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// takes an rfq out of the inbox of every bank
+func removeFromInboxes(stub shim.ChaincodeStubInterface, tradeID string) error {
+	banks, err := bankIDs(stub)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(banks); i++ {
+		err = delIndexEntry(stub, inboxIndex, banks[i], tradeID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// false once an rfq in an inbox can no longer be answered, because its trade moved past the quoting stage
+// or a corporate action changed the terms it was requested on
+func rfqOpen(stub shim.ChaincodeStubInterface, tradeID string, quoteID string) (bool, error) {
+	tradebyte, err := stub.GetState(tradeID)
+	if err != nil {
+		return false, errors.New("Error while getting trade info from ledger")
+	}
+	if len(tradebyte) == 0 {
+		return false, nil
+	}
+	var trade Trade
+	err = json.Unmarshal(tradebyte, &trade)
+	if err != nil {
+		return false, errors.New("Error while unmarshalling trade data")
+	}
+	if trade.Status != "Quote requested" && trade.Status != "Responded" {
+		return false, nil
+	}
+	rfqbyte, err := stub.GetState(quoteID)
+	if err != nil {
+		return false, errors.New("Error while reading quote request transaction from ledger")
+	}
+	var rfq Transaction
+	err = json.Unmarshal(rfqbyte, &rfq)
+	if err != nil {
+		return false, errors.New("Error while unmarshalling quote request data")
+	}
+	return checkUnderlyingCorporateActions(stub, rfq, rfq.Timestamp) == nil, nil
+}
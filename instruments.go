@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const instrumentIndex = "instrument" // symbol -> Instrument
+
+// reference data of a stock that options can be traded on
+type Instrument struct {
+	Symbol     string
+	Name       string
+	ISIN       string
+	Currency   string
+	Market     string  // calendar the instrument trades on, US when empty
+	LotSize    int     // contract quantities must be a multiple of the lot size
+	TickSize   float64 // prices must be a multiple of the tick size
+	Multiplier int     // shares delivered per contract
+	Tradable   bool    // false stops new quote requests, open trades can still settle
+
+	LastActionAt time.Time // quotes made before the last corporate action can no longer be executed
+}
+
+// shares per contract, instruments registered before multipliers deliver one share
+func (i Instrument) contractMultiplier() int {
+	if i.Multiplier <= 0 {
+		return 1
+	}
+	return i.Multiplier
+}
+
+// shares underlying a number of contracts, a zero multiplier counts as one share per contract
+func shares(contracts int, multiplier int) int {
+	if multiplier <= 0 {
+		multiplier = 1
+	}
+	return contracts * multiplier
+}
+
+// instruments written by init when missing
+var defaultInstruments = []Instrument{
+	{Symbol: "GOOGL", Name: "Alphabet Inc. Class A", ISIN: "US02079K3059", Currency: "USD", Market: defaultMarket, LotSize: 1, TickSize: 0.01, Multiplier: 1, Tradable: true},
+	{Symbol: "AAPL", Name: "Apple Inc.", ISIN: "US0378331005", Currency: "USD", Market: defaultMarket, LotSize: 1, TickSize: 0.01, Multiplier: 1, Tradable: true},
+	{Symbol: "MSFT", Name: "Microsoft Corporation", ISIN: "US5949181045", Currency: "USD", Market: defaultMarket, LotSize: 1, TickSize: 0.01, Multiplier: 1, Tradable: true},
+	{Symbol: "AMZN", Name: "Amazon.com Inc.", ISIN: "US0231351067", Currency: "USD", Market: defaultMarket, LotSize: 1, TickSize: 0.01, Multiplier: 1, Tradable: true},
+}
+
+func readInstrument(stub shim.ChaincodeStubInterface, symbol string) (Instrument, bool, error) {
+	var instrument Instrument
+	b, err := stub.GetState(indexKey(instrumentIndex, strings.ToUpper(symbol)))
+	if err != nil {
+		return instrument, false, errors.New("Error while getting instrument from ledger")
+	}
+	if len(b) == 0 {
+		return instrument, false, nil
+	}
+	err = json.Unmarshal(b, &instrument)
+	if err != nil {
+		return instrument, false, errors.New("Error while unmarshalling instrument")
+	}
+	return instrument, true, nil
+}
+
+func writeInstrument(stub shim.ChaincodeStubInterface, instrument Instrument) error {
+	b, err := json.Marshal(instrument)
+	if err != nil {
+		return errors.New("Error while marshalling instrument")
+	}
+	return putIndexEntry(stub, b, instrumentIndex, instrument.Symbol)
+}
+
+func seedInstruments(stub shim.ChaincodeStubInterface) error {
+	for i := 0; i < len(defaultInstruments); i++ {
+		_, ok, err := readInstrument(stub, defaultInstruments[i].Symbol)
+		if err != nil {
+			return err
+		}
+		if ok {
+			continue
+		}
+		err = writeInstrument(stub, defaultInstruments[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// registered instrument of a symbol, the error holds the rejection reason
+func getInstrument(stub shim.ChaincodeStubInterface, symbol string) (Instrument, error) {
+	instrument, ok, err := readInstrument(stub, symbol)
+	if err != nil {
+		return instrument, err
+	}
+	if !ok {
+		return instrument, errors.New("Error unknown instrument " + symbol)
+	}
+	return instrument, nil
+}
+
+// checks that a symbol can be requested in the given quantity
+func validateInstrumentOrder(stub shim.ChaincodeStubInterface, symbol string, quantity int) (Instrument, error) {
+	instrument, err := getInstrument(stub, symbol)
+	if err != nil {
+		return instrument, err
+	}
+	if !instrument.Tradable {
+		return instrument, errors.New("Error instrument " + instrument.Symbol + " is not tradable")
+	}
+	if quantity <= 0 {
+		return instrument, errors.New("Error quantity must be positive")
+	}
+	if instrument.LotSize > 0 && quantity%instrument.LotSize != 0 {
+		return instrument, fmt.Errorf("Error quantity %d is not a multiple of the lot size %d of %s", quantity, instrument.LotSize, instrument.Symbol)
+	}
+	return instrument, nil
+}
+
+// true when price lies on the tick grid of the instrument
+func onTick(instrument Instrument, price float64) bool {
+	if instrument.TickSize <= 0 {
+		return true
+	}
+	ticks := price / instrument.TickSize
+	return math.Abs(ticks-math.Floor(ticks+0.5)) < 1e-6
+}
+
+// used by the regulatory body to add or update an instrument
+/*			arg 0	:	StockSymbol
+			arg 1	:	Name
+			arg 2	:	ISIN
+			arg 3	:	Currency
+			arg 4	:	Lot size
+			arg 5	:	Tick size
+			arg 6	:	Multiplier, shares per contract
+			arg 7	:	Market of the holiday calendar
+			arg 8	:	Tradable true/ false
+			arg 9	:	RegBody EntityID
+*/
+func (t *SimpleChaincode) setInstrument(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 10 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	err := requireRegBody(stub, args[9], "maintain instruments")
+	if err != nil {
+		return nil, err
+	}
+	if args[0] == "" {
+		return nil, errors.New("Error instrument needs a symbol")
+	}
+	if len(args[2]) != 12 {
+		return nil, errors.New("Error invalid ISIN " + args[2])
+	}
+	if len(args[3]) != 3 {
+		return nil, errors.New("Error invalid currency " + args[3])
+	}
+	lotSize, err := strconv.Atoi(args[4])
+	if err != nil || lotSize <= 0 {
+		return nil, errors.New("Error invalid lot size")
+	}
+	tickSize, err := strconv.ParseFloat(args[5], 64)
+	if err != nil || tickSize <= 0 {
+		return nil, errors.New("Error invalid tick size")
+	}
+	multiplier, err := strconv.Atoi(args[6])
+	if err != nil || multiplier <= 0 {
+		return nil, errors.New("Error invalid multiplier")
+	}
+	calendar, err := calendarFor(stub, args[7])
+	if err != nil {
+		return nil, err
+	}
+	tradable, err := strconv.ParseBool(args[8])
+	if err != nil {
+		return nil, errors.New("Error invalid tradable flag")
+	}
+	instrument := Instrument{
+		Symbol:     strings.ToUpper(args[0]),
+		Name:       args[1],
+		ISIN:       strings.ToUpper(args[2]),
+		Currency:   strings.ToUpper(args[3]),
+		Market:     calendar.Market,
+		LotSize:    lotSize,
+		TickSize:   tickSize,
+		Multiplier: multiplier,
+		Tradable:   tradable,
+	}
+	// an update keeps the record of the last corporate action on the instrument
+	existing, ok, err := readInstrument(stub, instrument.Symbol)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		instrument.LastActionAt = existing.LastActionAt
+	}
+	return nil, writeInstrument(stub, instrument)
+}
+
+// all registered instruments, or only the tradable ones
+/*			arg 0	:	true to list tradable instruments only (optional)
+*/
+func (t *SimpleChaincode) getInstruments(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) > 1 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	tradableOnly := false
+	if len(args) == 1 {
+		var err error
+		tradableOnly, err = strconv.ParseBool(args[0])
+		if err != nil {
+			return nil, errors.New("Error invalid tradable flag")
+		}
+	}
+	entries, err := scanIndex(stub, instrumentIndex)
+	if err != nil {
+		return nil, err
+	}
+	instruments := []Instrument{}
+	for i := 0; i < len(entries); i++ {
+		var instrument Instrument
+		err = json.Unmarshal(entries[i].Value, &instrument)
+		if err != nil {
+			return nil, errors.New("Error while unmarshalling instrument")
+		}
+		if tradableOnly && !instrument.Tradable {
+			continue
+		}
+		instruments = append(instruments, instrument)
+	}
+	b, err := json.Marshal(instruments)
+	if err != nil {
+		return nil, errors.New("Error while marshalling instruments")
+	}
+	return b, nil
+}
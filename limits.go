@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const anyLimitKey = "*"                // matches every entity or every symbol of a position limit
+const positionLimitIndex = "posLimit"  // entityID, symbol -> PositionLimit
+const limitBreachIndex = "limitBreach" // sequence number -> LimitBreach
+
+// caps on open options, a zero cap is not checked
+//		entity and symbol set	: options of the entity on the symbol
+//		symbol set to *			: options of the entity on any symbol taken together
+//		entity set to *			: open interest on the symbol, the executed trades of all entities taken together
+type PositionLimit struct {
+	EntityID     string
+	Symbol       string
+	MaxContracts int
+	MaxNotional  float64 // shares x stock rate
+}
+
+// rejected request or execution
+type LimitBreach struct {
+	Seq           int
+	EntityID      string
+	Symbol        string
+	TransactionID string
+	TradeID       string
+	Stage         string  // Request or Execute
+	Contracts     int     // open contracts including the rejected ones
+	Notional      float64 // open notional including the rejected ones
+	Limit         PositionLimit
+	Reason        string
+	Timestamp     time.Time
+}
+
+// limits applying to options of an entity on a symbol
+func applicableLimits(stub shim.ChaincodeStubInterface, entityID string, symbol string) ([]PositionLimit, error) {
+	var limits []PositionLimit
+	keys := [][]string{{entityID, strings.ToUpper(symbol)}, {entityID, anyLimitKey}, {anyLimitKey, strings.ToUpper(symbol)}}
+	for i := 0; i < len(keys); i++ {
+		b, err := stub.GetState(indexKey(positionLimitIndex, keys[i]...))
+		if err != nil {
+			return nil, errors.New("Error while getting position limit from ledger")
+		}
+		if len(b) == 0 {
+			continue
+		}
+		var limit PositionLimit
+		err = json.Unmarshal(b, &limit)
+		if err != nil {
+			return nil, errors.New("Error while unmarshalling position limit")
+		}
+		limits = append(limits, limit)
+	}
+	return limits, nil
+}
+
+// open contracts and notional of an entity, on one symbol or on all of them for *, a basket counts on
+// each of its components by weight
+func openExposure(entity Entity, symbol string) (int, float64) {
+	contracts := 0
+	notional := 0.0
+	for i := 0; i < len(entity.Options); i++ {
+		o := entity.Options[i]
+		value := float64(shares(o.Quantity, o.Multiplier)) * o.StockRate
+		if symbol == anyLimitKey {
+			contracts += o.Quantity
+			notional += value
+			continue
+		}
+		c, n := exposureOn(o.Symbol, o.Basket, o.Quantity, value, symbol)
+		contracts += c
+		notional += n
+	}
+	return contracts, notional
+}
+
+// open contracts and notional of the executed trades of every entity on a symbol, each trade counted once,
+// basket trades by the weight of the symbol in the basket
+func openInterest(stub shim.ChaincodeStubInterface, symbol string) (int, float64, error) {
+	tradeIDs, err := tradeIDsFromIndex(stub, 0, symbolIndex, symbolIndexValue(symbol))
+	if err != nil {
+		return 0, 0, err
+	}
+	basketTradeIDs, err := tradeIDsFromIndex(stub, 0, basketIndex, symbolIndexValue(symbol))
+	if err != nil {
+		return 0, 0, err
+	}
+	tradeIDs = append(tradeIDs, basketTradeIDs...)
+	contracts := 0
+	notional := 0.0
+	for i := 0; i < len(tradeIDs); i++ {
+		tradebyte, err := stub.GetState(tradeIDs[i])
+		if err != nil {
+			return 0, 0, errors.New("Error while getting trade info from ledger")
+		}
+		var trade Trade
+		err = json.Unmarshal(tradebyte, &trade)
+		if err != nil {
+			return 0, 0, errors.New("Error while unmarshalling trade data")
+		}
+		if trade.Status != "Trade Executed" {
+			continue
+		}
+		terms, err := currentTerms(stub, trade)
+		if err != nil {
+			return 0, 0, err
+		}
+		c, n := exposureOn(terms.StockSymbol, terms.Basket, terms.Quantity, float64(shares(terms.Quantity, terms.Multiplier))*terms.StockRate, symbol)
+		contracts += c
+		notional += n
+	}
+	return contracts, notional, nil
+}
+
+func logLimitBreach(stub shim.ChaincodeStubInterface, breach LimitBreach) error {
+	num := 0
+	numbyte, err := stub.GetState("limitBreachNum")
+	if err != nil {
+		return errors.New("Error while getting limit breach number from ledger")
+	}
+	if len(numbyte) != 0 {
+		num, err = strconv.Atoi(string(numbyte))
+		if err != nil {
+			return errors.New("Error while converting limit breach number to integer")
+		}
+	}
+	num++
+	breach.Seq = num
+	breach.Timestamp, err = txTime(stub)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(breach)
+	if err != nil {
+		return errors.New("Error while marshalling limit breach")
+	}
+	err = putIndexEntry(stub, b, limitBreachIndex, fmt.Sprintf("%010d", num))
+	if err != nil {
+		return err
+	}
+	err = stub.PutState("limitBreachNum", []byte(strconv.Itoa(num)))
+	if err != nil {
+		return errors.New("Error while writing limit breach number to ledger")
+	}
+	return nil
+}
+
+// checks the limits of an entity taking on contracts more options, logs and returns the first breach
+// notional may be zero when it is not known yet, in which case only contracts are checked
+func checkPositionLimits(stub shim.ChaincodeStubInterface, entity Entity, symbol string, contracts int, notional float64, transactionID string, tradeID string, stage string) error {
+	limits, err := applicableLimits(stub, entity.EntityID, symbol)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(limits); i++ {
+		limit := limits[i]
+		openContracts, openNotional := openExposure(entity, limit.Symbol)
+		if limit.EntityID == anyLimitKey {
+			openContracts, openNotional, err = openInterest(stub, limit.Symbol)
+			if err != nil {
+				return err
+			}
+		}
+		openContracts += contracts
+		openNotional += notional
+		reason := ""
+		if limit.MaxContracts > 0 && openContracts > limit.MaxContracts {
+			reason = fmt.Sprintf("%d open contracts exceed the limit of %d", openContracts, limit.MaxContracts)
+		} else if limit.MaxNotional > 0 && notional > 0 && openNotional > limit.MaxNotional {
+			reason = fmt.Sprintf("open notional %.2f exceeds the limit of %.2f", openNotional, limit.MaxNotional)
+		}
+		if reason == "" {
+			continue
+		}
+		err = logLimitBreach(stub, LimitBreach{
+			EntityID:      entity.EntityID,
+			Symbol:        strings.ToUpper(symbol),
+			TransactionID: transactionID,
+			TradeID:       tradeID,
+			Stage:         stage,
+			Contracts:     openContracts,
+			Notional:      openNotional,
+			Limit:         limit,
+			Reason:        reason,
+		})
+		if err != nil {
+			return err
+		}
+		return errors.New("Error position limit breached for " + entity.EntityID + " on " + limit.Symbol + ": " + reason)
+	}
+	return nil
+}
+
+// used by the regulatory body to set or remove (both caps 0) a position limit
+/*			arg 0	:	EntityID or * for the open interest of all entities on the symbol
+			arg 1	:	StockSymbol or * for all symbols together
+			arg 2	:	Max open contracts (0 for no cap)
+			arg 3	:	Max open notional (0 for no cap)
+			arg 4	:	RegBody EntityID
+*/
+func (t *SimpleChaincode) setPositionLimit(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 5 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	regBody, err := getEntity(stub, args[4])
+	if err != nil {
+		return nil, err
+	}
+	if regBody.EntityType != "RegBody" {
+		return nil, errors.New("Error only Regulatory Body can set position limits")
+	}
+	if args[0] == anyLimitKey && args[1] == anyLimitKey {
+		return nil, errors.New("Error position limit needs an entity or a symbol")
+	}
+	if args[0] != anyLimitKey {
+		_, err = getEntity(stub, args[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+	maxContracts, err := strconv.Atoi(args[2])
+	if err != nil || maxContracts < 0 {
+		return nil, errors.New("Error invalid max contracts")
+	}
+	maxNotional, err := strconv.ParseFloat(args[3], 64)
+	if err != nil || maxNotional < 0 {
+		return nil, errors.New("Error invalid max notional")
+	}
+	limit := PositionLimit{EntityID: args[0], Symbol: strings.ToUpper(args[1]), MaxContracts: maxContracts, MaxNotional: maxNotional}
+	if maxContracts == 0 && maxNotional == 0 {
+		return nil, delIndexEntry(stub, positionLimitIndex, limit.EntityID, limit.Symbol)
+	}
+	b, err := json.Marshal(limit)
+	if err != nil {
+		return nil, errors.New("Error while marshalling position limit")
+	}
+	return nil, putIndexEntry(stub, b, positionLimitIndex, limit.EntityID, limit.Symbol)
+}
+
+// all position limits, or those applying to an entity
+/*			arg 0	:	EntityID (optional)
+*/
+func (t *SimpleChaincode) getPositionLimits(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) > 1 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	entries, err := scanIndex(stub, positionLimitIndex)
+	if err != nil {
+		return nil, err
+	}
+	limits := []PositionLimit{}
+	for i := 0; i < len(entries); i++ {
+		var limit PositionLimit
+		err = json.Unmarshal(entries[i].Value, &limit)
+		if err != nil {
+			return nil, errors.New("Error while unmarshalling position limit")
+		}
+		if len(args) == 1 && limit.EntityID != args[0] && limit.EntityID != anyLimitKey {
+			continue
+		}
+		limits = append(limits, limit)
+	}
+	b, err := json.Marshal(limits)
+	if err != nil {
+		return nil, errors.New("Error while marshalling position limits")
+	}
+	return b, nil
+}
+
+// breaches rejected so far, oldest first
+/*			arg 0	:	RegBody EntityID
+*/
+func (t *SimpleChaincode) getLimitBreaches(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	regBody, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if regBody.EntityType != "RegBody" {
+		return nil, errors.New("Error only Regulatory Body can access limit breaches")
+	}
+	entries, err := scanIndex(stub, limitBreachIndex)
+	if err != nil {
+		return nil, err
+	}
+	breaches := make([]LimitBreach, len(entries))
+	for i := 0; i < len(entries); i++ {
+		err = json.Unmarshal(entries[i].Value, &breaches[i])
+		if err != nil {
+			return nil, errors.New("Error while unmarshalling limit breach")
+		}
+	}
+	b, err := json.Marshal(breaches)
+	if err != nil {
+		return nil, errors.New("Error while marshalling limit breaches")
+	}
+	return b, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPositionLimitBreach(t *testing.T) {
+	cc := new(SimpleChaincode)
+	stub := newTestLedger(t, time.Date(2026, 3, 2, 15, 0, 0, 0, time.UTC))
+	_, err := cc.setPositionLimit(stub, []string{entity1, "AAPL", "5", "0", entity1})
+	if err == nil {
+		t.Errorf("expected an error for a limit set by a client")
+	}
+	_, err = cc.setPositionLimit(stub, []string{entity1, "AAPL", "5", "0", entity4})
+	if err != nil {
+		t.Fatalf("set limit: %v", err)
+	}
+
+	// within the limit
+	transactionID, _ := cc.requestForQuote(stub, []string{"call", "AAPL", "5", entity1})
+	if got := transactionStatus(stub, string(transactionID)); got != "Success" {
+		t.Errorf("request within the limit: got status %q", got)
+	}
+	// open requests are not positions, so only the size of the new request counts
+	_, _ = cc.requestForQuote(stub, []string{"call", "AAPL", "6", entity1})
+	if got := transactionStatus(stub, "trans1002"); !strings.HasPrefix(got, "Error position limit breached") {
+		t.Errorf("request above the limit: got status %q", got)
+	}
+	if stub.state["trade1002"] != nil {
+		t.Errorf("rejected request created a trade")
+	}
+
+	b, err := cc.getLimitBreaches(stub, []string{entity4})
+	if err != nil {
+		t.Fatalf("get breaches: %v", err)
+	}
+	var breaches []LimitBreach
+	_ = json.Unmarshal(b, &breaches)
+	if len(breaches) != 1 {
+		t.Fatalf("got %d breaches, want 1", len(breaches))
+	}
+	breach := breaches[0]
+	if breach.EntityID != entity1 || breach.Symbol != "AAPL" || breach.TransactionID != "trans1002" || breach.Stage != "Request" || breach.Contracts != 6 {
+		t.Errorf("breach %+v does not record the rejected request", breach)
+	}
+	if _, err = cc.getLimitBreaches(stub, []string{entity2}); err == nil {
+		t.Errorf("expected an error for breaches read by a bank")
+	}
+}
+
+func TestBasketLimitsByComponent(t *testing.T) {
+	cc := new(SimpleChaincode)
+	stub := newTestLedger(t, time.Date(2026, 3, 2, 15, 0, 0, 0, time.UTC))
+	_, err := cc.setPositionLimit(stub, []string{entity1, "MSFT", "5", "0", entity4})
+	if err != nil {
+		t.Fatalf("set limit: %v", err)
+	}
+	basket := `{"Type":"Basket","Components":[{"Symbol":"AAPL","Weight":2},{"Symbol":"MSFT","Weight":1}]}`
+
+	// the basket's name carries no limit, its MSFT component does
+	transactionID := nextTransactionID(stub)
+	_, _ = cc.requestForQuote(stub, []string{"call", "TECH", "6", entity1, basket})
+	if got := transactionStatus(stub, transactionID); !strings.HasPrefix(got, "Error position limit breached") {
+		t.Errorf("basket request above the component limit: got status %q", got)
+	}
+	tradeID := executeTestCall(t, stub, "TECH", "3", "14.68", "130", basket)
+
+	var want = map[string]int{"AAPL": 6, "MSFT": 3, "TECH": 0}
+	for symbol, contracts := range want {
+		got, _, err := openInterest(stub, symbol)
+		if err != nil {
+			t.Fatalf("open interest on %s: %v", symbol, err)
+		}
+		if got != contracts {
+			t.Errorf("open interest on %s: got %d contracts, want %d", symbol, got, contracts)
+		}
+	}
+	client, _ := getEntity(stub, entity1)
+	if got, _ := openExposure(client, "MSFT"); got != 3 {
+		t.Errorf("client exposure on MSFT: got %d contracts, want 3", got)
+	}
+	if got, _ := openExposure(client, anyLimitKey); got != 3 {
+		t.Errorf("client exposure on all symbols: got %d contracts, want 3", got)
+	}
+
+	// a further 3 basket contracts take the client to 6 on MSFT
+	transactionID = nextTransactionID(stub)
+	_, _ = cc.requestForQuote(stub, []string{"call", "TECH", "3", entity1, basket})
+	if got := transactionStatus(stub, transactionID); !strings.HasPrefix(got, "Error position limit breached") {
+		t.Errorf("basket request above the component limit with %s open: got status %q", tradeID, got)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const marketDataMaxAge = 24 * time.Hour // published prices older than this are stale
+
+const marketDataIndex = "marketData" // symbol, publish time -> MarketData, the price history of a symbol
+
+type MarketData struct {
+	Symbol      string
+	Spot        float64
+	Close       float64 // previous close
+	Volatility  float64 // implied volatility
+	Timestamp   time.Time
+	PublisherID string
+}
+
+type MarketDataStatus struct {
+	MarketData
+	Stale bool
+}
+
+func marketDataKey(symbol string) string {
+	return "marketData_" + symbol
+}
+
+// publish time as a fixed width attribute of the history index so that observations sort by time
+func marketDataTimeAttr(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000000000Z")
+}
+
+func (m MarketData) isStale(now time.Time) bool {
+	return now.Sub(m.Timestamp) > marketDataMaxAge
+}
+
+// used by the price publisher to write spot, close and implied volatility of a symbol
+/*			arg 0	:	StockSymbol
+			arg 1	:	Spot price
+			arg 2	:	Close price
+			arg 3	:	Implied volatility
+			arg 4	:	Publisher EntityID
+*/
+func (t *SimpleChaincode) publishMarketData(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 5 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	publisherbyte, err := stub.GetState(args[4])
+	if err != nil {
+		return nil, errors.New("Error while getting publisher info from ledger")
+	}
+	var publisher Entity
+	err = json.Unmarshal(publisherbyte, &publisher)
+	if err != nil {
+		return nil, errors.New("Error while unmarshalling publisher data")
+	}
+	if publisher.EntityType != "PricePublisher" {
+		return nil, errors.New("Error only an authorized price publisher can publish market data")
+	}
+	spot, err := strconv.ParseFloat(args[1], 64)
+	if err != nil || spot <= 0 {
+		return nil, errors.New("Error invalid spot price")
+	}
+	closePrice, err := strconv.ParseFloat(args[2], 64)
+	if err != nil || closePrice <= 0 {
+		return nil, errors.New("Error invalid close price")
+	}
+	vol, err := strconv.ParseFloat(args[3], 64)
+	if err != nil || vol <= 0 {
+		return nil, errors.New("Error invalid volatility")
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	m := MarketData{
+		Symbol:      strings.ToUpper(args[0]),
+		Spot:        spot,
+		Close:       closePrice,
+		Volatility:  vol,
+		Timestamp:   now,
+		PublisherID: publisher.EntityID,
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, errors.New("Error while marshalling market data")
+	}
+	err = stub.PutState(marketDataKey(m.Symbol), b)
+	if err != nil {
+		return nil, errors.New("Error while writing market data to ledger")
+	}
+
+	// one history entry per observation
+	err = putIndexEntry(stub, b, marketDataIndex, m.Symbol, marketDataTimeAttr(m.Timestamp))
+	if err != nil {
+		return nil, err
+	}
+	// barriers hit by the new spot knock in or out
+	monitorBarriers(stub, m)
+	return nil, nil
+}
+
+// latest published market data of a symbol, stale or not
+func readMarketData(stub shim.ChaincodeStubInterface, symbol string) (MarketData, error) {
+	var m MarketData
+	b, err := stub.GetState(marketDataKey(strings.ToUpper(symbol)))
+	if err != nil {
+		return m, errors.New("Error while getting market data from ledger")
+	}
+	if len(b) == 0 {
+		return m, errors.New("Error no market data published for " + symbol)
+	}
+	err = json.Unmarshal(b, &m)
+	if err != nil {
+		return m, errors.New("Error while unmarshalling market data")
+	}
+	return m, nil
+}
+
+// latest market data of a symbol for use in other chaincode functions, fails when stale
+func getFreshMarketData(stub shim.ChaincodeStubInterface, symbol string) (MarketData, error) {
+	m, err := readMarketData(stub, symbol)
+	if err != nil {
+		return m, err
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return m, err
+	}
+	if m.isStale(now) {
+		return m, errors.New("Error market data for " + symbol + " is stale, last published " + m.Timestamp.Format(time.RFC3339))
+	}
+	return m, nil
+}
+
+/*			arg 0	:	StockSymbol
+*/
+func (t *SimpleChaincode) getMarketData(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	m, err := readMarketData(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(MarketDataStatus{MarketData: m, Stale: m.isStale(now)})
+	if err != nil {
+		return nil, errors.New("Error while marshalling market data")
+	}
+	return b, nil
+}
+
+/*			arg 0	:	StockSymbol
+			arg 1	:	From date YYYY-MM-DD (optional)
+			arg 2	:	To date YYYY-MM-DD (optional, inclusive)
+*/
+func (t *SimpleChaincode) getMarketDataHistory(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 && len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	from := ""
+	to := indexKeyEnd
+	if len(args) == 3 {
+		fromDate, err := time.Parse("2006-01-02", args[1])
+		if err != nil {
+			return nil, errors.New("Error invalid from date")
+		}
+		toDate, err := time.Parse("2006-01-02", args[2])
+		if err != nil {
+			return nil, errors.New("Error invalid to date")
+		}
+		from = fromDate.Format("2006-01-02")
+		to = toDate.AddDate(0, 0, 1).Format("2006-01-02")
+	}
+	history, err := readMarketDataHistory(stub, args[0], from, to)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(history)
+	if err != nil {
+		return nil, errors.New("Error while marshalling market data history")
+	}
+	return b, nil
+}
+
+// published observations of a symbol in order, from and to bound the publish time attribute
+func readMarketDataHistory(stub shim.ChaincodeStubInterface, symbol string, from string, to string) ([]MarketData, error) {
+	entries, err := scanIndexRange(stub, marketDataIndex, from, to, strings.ToUpper(symbol))
+	if err != nil {
+		return nil, err
+	}
+	history := make([]MarketData, len(entries))
+	for i := 0; i < len(entries); i++ {
+		err = json.Unmarshal(entries[i].Value, &history[i])
+		if err != nil {
+			return nil, errors.New("Error while unmarshalling market data history")
+		}
+	}
+	return history, nil
+}
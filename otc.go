@@ -0,0 +1,656 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// linear products quoted through the rfq workflow, requested with the product as the option type
+const forwardProduct = "Forward" // shares delivered at maturity against the forward price quoted as stock rate
+const swapProduct = "Swap"       // total return of the stock against financing, exchanged in cash on every reset
+
+const maxResets = 60 // five years of monthly resets
+
+// reset schedule and financing of a total return swap, the stock rate is the reference price of the current period
+type SwapTerms struct {
+	ResetDates    []time.Time // business days of the instrument's market before maturity, ascending
+	FinancingRate float64     // annual percent the client pays on the reference notional, ACT/360
+	AccrualStart  time.Time   // start of the current period, the time of execution or of the last reset
+}
+
+func linearProduct(s string) (string, bool) {
+	for _, p := range []string{forwardProduct, swapProduct} {
+		if strings.EqualFold(s, p) {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// true for forwards and swaps, which are settled at maturity instead of exercised
+func isLinear(optionType string) bool {
+	_, ok := linearProduct(optionType)
+	return ok
+}
+
+func dateOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// forward and swap quotes are checked against the strike band only, the premium tolerance does not apply
+func validateLinearQuote(stub shim.ChaincodeStubInterface, quote Transaction) error {
+	calendar, err := underlyingCalendar(stub, quote)
+	if err != nil {
+		return err
+	}
+	err = calendar.checkBusinessDay(quote.SettlementDate, "maturity")
+	if err != nil {
+		return err
+	}
+	if quote.OptionPrice < 0 {
+		return errors.New("Error quote rejected: upfront payment or financing rate must not be negative")
+	}
+	if quote.StockRate <= 0 {
+		return errors.New("Error quote rejected: stock rate must be positive")
+	}
+	rule, ok, err := quoteRuleFor(stub, quote.StockSymbol)
+	if err != nil {
+		return err
+	}
+	if !ok || rule.StrikeBandPct <= 0 {
+		return nil
+	}
+	m, err := getFreshMarketData(stub, quote.StockSymbol)
+	if err != nil {
+		return errors.New("Error quote rejected: cannot check reference price, " + err.Error())
+	}
+	return checkStrikeBand(rule, m.Spot, quote.StockRate)
+}
+
+// greeks of a linear value function by bumping its inputs
+func linearGreeks(price func(spot float64, rate float64, t float64) float64, spot float64, vol float64, rate float64, t float64) (Greeks, error) {
+	return bumpGreeks(func(spot float64, vol float64, rate float64, t float64) (float64, error) {
+		return price(spot, rate, t), nil
+	}, spot, vol, rate, t)
+}
+
+// shares delivered at maturity against the forward price quoted as stock rate, the option price is an
+// upfront payment by the client; like option premiums, the forward price and upfront payment are recorded on
+// the transactions and paid outside the ledger
+type forwardContract struct{}
+
+func (p forwardContract) Name() string {
+	return forwardProduct
+}
+
+func (p forwardContract) ValidateRequest(stub shim.ChaincodeStubInterface, tran *Transaction, terms []string) error {
+	if len(terms) > 0 {
+		return errors.New("Error forwards take no terms")
+	}
+	instrument, err := validateInstrumentOrder(stub, tran.StockSymbol, tran.Quantity)
+	if err != nil {
+		return err
+	}
+	tran.OptionType = forwardProduct
+	tran.StockSymbol = instrument.Symbol
+	tran.Multiplier = instrument.contractMultiplier()
+	return nil
+}
+
+func (p forwardContract) ValidateQuote(stub shim.ChaincodeStubInterface, quote *Transaction) error {
+	err := validateLinearQuote(stub, *quote)
+	if err != nil {
+		return err
+	}
+	return checkQuoteTicks(stub, *quote, quote.OptionPrice, quote.StockRate)
+}
+
+func (p forwardContract) Execute(stub shim.ChaincodeStubInterface, tran *Transaction) error {
+	return nil
+}
+
+// only the shares move, the client pays the forward price outside the ledger
+func (p forwardContract) Settle(stub shim.ChaincodeStubInterface, tran *Transaction, client *Entity, bank *Entity) error {
+	return deliverShares(bank, client, tran.StockSymbol, shares(tran.Quantity, tran.Multiplier))
+}
+
+// spot against the discounted forward price
+func (p forwardContract) Value(stub shim.ChaincodeStubInterface, exerciseStyle string, o Option, spot float64, vol float64, rate float64, t float64) (float64, error) {
+	return p.price(o, spot, rate, t), nil
+}
+
+func (p forwardContract) Greeks(stub shim.ChaincodeStubInterface, o Option, spot float64, vol float64, rate float64, t float64) (Greeks, error) {
+	return linearGreeks(func(spot float64, rate float64, t float64) float64 {
+		return p.price(o, spot, rate, t)
+	}, spot, vol, rate, t)
+}
+
+func (p forwardContract) price(o Option, spot float64, rate float64, t float64) float64 {
+	if t < 0 {
+		t = 0
+	}
+	return spot - o.StockRate*math.Exp(-rate*t)
+}
+
+// total return of the stock against financing on the reference price, exchanged in cash on every reset and
+// at maturity; quoted with the financing rate as option price and the initial reference price as stock rate
+type totalReturnSwap struct{}
+
+func (p totalReturnSwap) Name() string {
+	return swapProduct
+}
+
+// takes an optional reset schedule, e.g. {"ResetDates":["2026-12-16","2027-03-17"]}
+func (p totalReturnSwap) ValidateRequest(stub shim.ChaincodeStubInterface, tran *Transaction, terms []string) error {
+	instrument, err := validateInstrumentOrder(stub, tran.StockSymbol, tran.Quantity)
+	if err != nil {
+		return err
+	}
+	tran.OptionType = swapProduct
+	tran.StockSymbol = instrument.Symbol
+	tran.Multiplier = instrument.contractMultiplier()
+	swap := SwapTerms{}
+	tran.Swap = &swap
+	if len(terms) == 0 {
+		return nil
+	}
+	var in struct {
+		Type       string
+		ResetDates []string
+	}
+	err = json.Unmarshal([]byte(terms[0]), &in)
+	if err != nil || in.Type != "" {
+		return errors.New("Error invalid swap terms")
+	}
+	if len(in.ResetDates) > maxResets {
+		return errors.New("Error swaps take at most 60 reset dates")
+	}
+	calendar, err := instrumentCalendar(stub, tran.StockSymbol)
+	if err != nil {
+		return err
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(in.ResetDates); i++ {
+		d, err := time.Parse("2006-01-02", in.ResetDates[i])
+		if err != nil {
+			return errors.New("Error invalid reset date " + in.ResetDates[i])
+		}
+		if !d.After(dateOf(now)) {
+			return errors.New("Error reset date " + in.ResetDates[i] + " has passed")
+		}
+		err = calendar.checkBusinessDay(d, "reset date")
+		if err != nil {
+			return err
+		}
+		if i > 0 && !d.After(swap.ResetDates[i-1]) {
+			return errors.New("Error reset dates must be ascending")
+		}
+		swap.ResetDates = append(swap.ResetDates, d)
+	}
+	return nil
+}
+
+func (p totalReturnSwap) ValidateQuote(stub shim.ChaincodeStubInterface, quote *Transaction) error {
+	err := validateLinearQuote(stub, *quote)
+	if err != nil {
+		return err
+	}
+	if len(quote.Swap.ResetDates) > 0 {
+		last := quote.Swap.ResetDates[len(quote.Swap.ResetDates)-1]
+		if !last.Before(quote.SettlementDate) {
+			return errors.New("Error reset date " + last.Format("2006-01-02") + " is not before the maturity " + quote.SettlementDate.Format("2006-01-02"))
+		}
+	}
+	err = checkQuoteTicks(stub, *quote, quote.StockRate)
+	if err != nil {
+		return err
+	}
+	// a swap is quoted as a financing rate and has no premium
+	swap := *quote.Swap
+	swap.FinancingRate = quote.OptionPrice
+	quote.Swap = &swap
+	quote.OptionPrice = 0
+	return nil
+}
+
+// financing accrues from execution
+func (p totalReturnSwap) Execute(stub shim.ChaincodeStubInterface, tran *Transaction) error {
+	swap := *tran.Swap
+	swap.AccrualStart = tran.Timestamp
+	tran.Swap = &swap
+	return nil
+}
+
+// the final exchange of cash flows, no shares move; the cash is recorded on the Settle transaction and paid
+// outside the ledger
+func (p totalReturnSwap) Settle(stub shim.ChaincodeStubInterface, tran *Transaction, client *Entity, bank *Entity) error {
+	m, err := getFreshMarketData(stub, tran.StockSymbol)
+	if err != nil {
+		return err
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	tran.CashSettlement, tran.Swap, err = swapReset(stub, *tran, m.Spot, now)
+	return err
+}
+
+// return since the last reset less the financing accrued on the reference price
+func (p totalReturnSwap) Value(stub shim.ChaincodeStubInterface, exerciseStyle string, o Option, spot float64, vol float64, rate float64, t float64) (float64, error) {
+	now, err := txTime(stub)
+	if err != nil {
+		return 0, err
+	}
+	return p.price(o, spot, now), nil
+}
+
+func (p totalReturnSwap) Greeks(stub shim.ChaincodeStubInterface, o Option, spot float64, vol float64, rate float64, t float64) (Greeks, error) {
+	now, err := txTime(stub)
+	if err != nil {
+		return Greeks{}, err
+	}
+	return linearGreeks(func(spot float64, rate float64, t float64) float64 {
+		return p.price(o, spot, now)
+	}, spot, vol, rate, t)
+}
+
+func (p totalReturnSwap) price(o Option, spot float64, now time.Time) float64 {
+	if o.Swap == nil || o.Swap.AccrualStart.IsZero() {
+		return spot - o.StockRate
+	}
+	return spot - o.StockRate - o.StockRate*o.Swap.accrued(now)
+}
+
+// financing per unit of reference notional accrued over the days from the start of the period to d
+func (s SwapTerms) accrued(d time.Time) float64 {
+	days := dateOf(d).Sub(dateOf(s.AccrualStart)).Hours() / 24
+	if days < 0 {
+		days = 0
+	}
+	return s.FinancingRate / 100 * days / 360
+}
+
+// true when a reset date has been reached since the start of the period
+func (s SwapTerms) resetDue(d time.Time) bool {
+	for i := 0; i < len(s.ResetDates); i++ {
+		if s.ResetDates[i].After(s.AccrualStart) && !s.ResetDates[i].After(d) {
+			return true
+		}
+	}
+	return false
+}
+
+// cash dividends per share of a symbol that went ex after from and no later than to
+func dividendsBetween(stub shim.ChaincodeStubInterface, symbol string, from time.Time, to time.Time) (float64, error) {
+	numbyte, err := stub.GetState("currentCorporateActionNum")
+	if err != nil {
+		return 0, errors.New("Error while getting currentCorporateActionNum from ledger")
+	}
+	num, _ := strconv.Atoi(string(numbyte))
+	total := 0.0
+	for ; num > 1000; num-- {
+		b, err := stub.GetState("corpAction" + strconv.Itoa(num))
+		if err != nil {
+			return 0, errors.New("Error while getting corporate action from ledger")
+		}
+		var action CorporateAction
+		err = json.Unmarshal(b, &action)
+		if err != nil {
+			return 0, errors.New("Error while unmarshalling corporate action")
+		}
+		if action.Type != cashDividendAction || !strings.EqualFold(action.Symbol, symbol) {
+			continue
+		}
+		if action.EffectiveAt.After(from) && !action.EffectiveAt.After(to) {
+			total += action.Amount
+		}
+	}
+	return total, nil
+}
+
+// cash the client receives when the legs of a swap are exchanged at spot, negative when it pays, and the
+// terms of the next period starting now; the equity leg is the price return plus dividends, the financing leg
+// is paid on the reference notional
+func swapReset(stub shim.ChaincodeStubInterface, terms Transaction, spot float64, now time.Time) (float64, *SwapTerms, error) {
+	dividends, err := dividendsBetween(stub, terms.StockSymbol, terms.Swap.AccrualStart, now)
+	if err != nil {
+		return 0, nil, err
+	}
+	n := float64(shares(terms.Quantity, terms.Multiplier))
+	equity := (spot - terms.StockRate + dividends) * n
+	financing := terms.StockRate * n * terms.Swap.accrued(now)
+	swap := *terms.Swap
+	swap.AccrualStart = now
+	return equity - financing, &swap, nil
+}
+
+// P&L of the holder at settlement before any upfront payment, the cash of a swap's final exchange or the
+// value of the shares a forward delivers against the forward price
+func linearPayoff(settle Transaction) float64 {
+	if settle.Swap != nil {
+		return settle.CashSettlement
+	}
+	return (settle.SpotPrice - settle.StockRate) * float64(shares(settle.Quantity, settle.Multiplier))
+}
+
+func isLinearTrade(stub shim.ChaincodeStubInterface, tradeID string) bool {
+	tradebyte, err := stub.GetState(tradeID)
+	if err != nil || len(tradebyte) == 0 {
+		return false
+	}
+	var trade Trade
+	err = json.Unmarshal(tradebyte, &trade)
+	if err != nil {
+		return false
+	}
+	return isLinear(trade.TradeType)
+}
+
+func removeOption(entity *Entity, tradeID string) {
+	var options []Option
+	for i := 0; i < len(entity.Options); i++ {
+		if entity.Options[i].TradeID != tradeID {
+			options = append(options, entity.Options[i])
+		}
+	}
+	entity.Options = options
+}
+
+// moves n shares of a symbol between portfolios, fails when the sender holds too few
+func deliverShares(from *Entity, to *Entity, symbol string, n int) error {
+	found := false
+	for i := 0; i < len(from.Portfolio); i++ {
+		if from.Portfolio[i].Symbol == symbol {
+			if from.Portfolio[i].Quantity < n {
+				break
+			}
+			from.Portfolio[i].Quantity = from.Portfolio[i].Quantity - n
+			found = true
+			break
+		}
+	}
+	if !found {
+		return errors.New("Error insufficient stock quantity to complete the transaction")
+	}
+	for i := 0; i < len(to.Portfolio); i++ {
+		if to.Portfolio[i].Symbol == symbol {
+			to.Portfolio[i].Quantity = to.Portfolio[i].Quantity + n
+			return nil
+		}
+	}
+	to.Portfolio = append(to.Portfolio, Stock{Symbol: symbol, Quantity: n})
+	return nil
+}
+
+// carries the reference price and period of a swap reset into the options of an entity
+func applySwapReset(stub shim.ChaincodeStubInterface, entityID string, tran Transaction) error {
+	entity, err := getEntity(stub, entityID)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(entity.Options); i++ {
+		if entity.Options[i].TradeID == tran.TradeID {
+			entity.Options[i].StockRate = tran.StockRate
+			entity.Options[i].Swap = tran.Swap
+		}
+	}
+	err = recordPositionChanges(stub, entity, tran.TransactionID, tran.TradeID)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(entity)
+	if err != nil {
+		return errors.New("Error while marshalling entity data")
+	}
+	err = stub.PutState(entity.EntityID, b)
+	if err != nil {
+		return errors.New("Error while writing entity to ledger")
+	}
+	return nil
+}
+
+// settles a forward or swap on or after its maturity, called through tradeSet; forwards deliver the shares
+// against the forward price and swaps make their final exchange of cash flows
+/*			arg 0	:	TradeID
+			arg 1	:	Yes, forwards and swaps cannot be walked away from
+			arg 2	:	EntityID of the client or the bank
+*/
+func (t *SimpleChaincode) settleContract(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	ctidByte, err := stub.GetState("currentTransactionNum")
+	if err != nil {
+		return nil, errors.New("Error while getting currentTransactionNum from ledger")
+	}
+	tid, err := strconv.Atoi(string(ctidByte))
+	if err != nil {
+		return nil, errors.New("Error while converting ctidByte to integer")
+	}
+	tid = tid + 1
+	transactionID := "trans" + strconv.Itoa(tid)
+	fail := func(msg string) ([]byte, error) {
+		_ = updateTransactionStatus(stub, transactionID, msg)
+		return nil, nil
+	}
+
+	tradebyte, err := stub.GetState(args[0])
+	if err != nil {
+		return fail("Error while getting trade info from ledger")
+	}
+	var trade Trade
+	err = json.Unmarshal(tradebyte, &trade)
+	if err != nil {
+		return fail("Error while unmarshalling trade data")
+	}
+	if trade.Status != "Trade Executed" {
+		return fail("Error cannot settle trade in status " + trade.Status)
+	}
+	terms, err := currentTerms(stub, trade)
+	if err != nil {
+		return fail(err.Error())
+	}
+	if args[2] != terms.ClientID && args[2] != terms.BankID {
+		return fail("Error only the client or the bank of trade " + trade.TradeID + " can settle it")
+	}
+	if strings.ToLower(args[1]) != "yes" {
+		return fail("Error a " + strings.ToLower(terms.OptionType) + " cannot be cancelled once executed")
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return fail(err.Error())
+	}
+	if now.Before(terms.SettlementDate) {
+		return fail("Error " + strings.ToLower(terms.OptionType) + " matures on " + terms.SettlementDate.Format("2006-01-02"))
+	}
+	err = checkUnderlyingHalts(stub, terms, terms.ClientID, terms.BankID)
+	if err != nil {
+		return fail(err.Error())
+	}
+	// both products settle against the published spot
+	m, err := getFreshMarketData(stub, terms.StockSymbol)
+	if err != nil {
+		return fail(err.Error())
+	}
+	calendar, err := underlyingCalendar(stub, terms)
+	if err != nil {
+		return fail(err.Error())
+	}
+	deliveryDate, err := calendar.addBusinessDays(now, calendar.SettlementDays)
+	if err != nil {
+		return fail(err.Error())
+	}
+
+	tran := closingTransaction(terms, transactionID, "Settle", args[2], now)
+	tran.SpotPrice = m.Spot
+	tran.DeliveryDate = deliveryDate
+
+	client, err := getEntity(stub, terms.ClientID)
+	if err != nil {
+		return fail(err.Error())
+	}
+	bank, err := getEntity(stub, terms.BankID)
+	if err != nil {
+		return fail(err.Error())
+	}
+	removeOption(&client, trade.TradeID)
+	removeOption(&bank, trade.TradeID)
+	product, err := productFor(terms.OptionType)
+	if err != nil {
+		return fail(err.Error())
+	}
+	err = product.Settle(stub, &tran, &client, &bank)
+	if err != nil {
+		return fail(err.Error())
+	}
+
+	err = writeTransaction(stub, tran)
+	if err != nil {
+		return fail(err.Error())
+	}
+	parties := []Entity{client, bank}
+	for i := 0; i < len(parties); i++ {
+		err = recordPositionChanges(stub, parties[i], transactionID, trade.TradeID)
+		if err != nil {
+			return fail(err.Error())
+		}
+		b, err := json.Marshal(parties[i])
+		if err != nil {
+			return fail("Error while marshalling entity data")
+		}
+		err = stub.PutState(parties[i].EntityID, b)
+		if err != nil {
+			return fail("Error while writing entity to ledger")
+		}
+	}
+	err = updateTradeState(stub, trade.TradeID, transactionID, "Trade Settled")
+	if err != nil {
+		return fail("Error while updating trade state")
+	}
+	err = stub.PutState("currentTransactionNum", []byte(strconv.Itoa(tid)))
+	if err != nil {
+		return fail("Error while writing currentTransactionNum to ledger")
+	}
+	err = emitTradeEvent(stub, newTradeEvent(tran, "Trade Settled"))
+	if err != nil {
+		return fail(err.Error())
+	}
+	return nil, nil
+}
+
+// used by the price publisher to exchange the cash flows of a swap on a reset date at the published spot,
+// the spot becomes the reference price of the next period; missed reset dates are rolled into the latest one.
+// The cash is recorded on the Reset transaction and paid outside the ledger, no balances move
+/*			arg 0	:	TradeID
+			arg 1	:	Publisher EntityID
+*/
+func (t *SimpleChaincode) resetSwap(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	ctidByte, err := stub.GetState("currentTransactionNum")
+	if err != nil {
+		return nil, errors.New("Error while getting currentTransactionNum from ledger")
+	}
+	tid, err := strconv.Atoi(string(ctidByte))
+	if err != nil {
+		return nil, errors.New("Error while converting ctidByte to integer")
+	}
+	tid = tid + 1
+	transactionID := "trans" + strconv.Itoa(tid)
+	fail := func(msg string) ([]byte, error) {
+		_ = updateTransactionStatus(stub, transactionID, msg)
+		return nil, nil
+	}
+
+	publisher, err := getEntity(stub, args[1])
+	if err != nil {
+		return fail(err.Error())
+	}
+	if publisher.EntityType != "PricePublisher" {
+		return fail("Error only an authorized price publisher can reset swaps")
+	}
+	tradebyte, err := stub.GetState(args[0])
+	if err != nil {
+		return fail("Error while getting trade info from ledger")
+	}
+	var trade Trade
+	err = json.Unmarshal(tradebyte, &trade)
+	if err != nil {
+		return fail("Error while unmarshalling trade data")
+	}
+	if trade.Status != "Trade Executed" {
+		return fail("Error cannot reset trade in status " + trade.Status)
+	}
+	terms, err := currentTerms(stub, trade)
+	if err != nil {
+		return fail(err.Error())
+	}
+	if terms.Swap == nil {
+		return fail("Error trade " + trade.TradeID + " is not a swap")
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return fail(err.Error())
+	}
+	if !terms.Swap.resetDue(now) {
+		return fail("Error no reset of trade " + trade.TradeID + " is due, the final exchange is made by settling the swap")
+	}
+	err = checkUnderlyingHalts(stub, terms)
+	if err != nil {
+		return fail(err.Error())
+	}
+	m, err := getFreshMarketData(stub, terms.StockSymbol)
+	if err != nil {
+		return fail(err.Error())
+	}
+
+	tran := terms
+	tran.TransactionID = transactionID
+	tran.TransactionType = "Reset"
+	tran.CashSettlement, tran.Swap, err = swapReset(stub, terms, m.Spot, now)
+	if err != nil {
+		return fail(err.Error())
+	}
+	tran.StockRate = m.Spot
+	tran.SpotPrice = m.Spot
+	tran.DeliveryDate = time.Time{}
+	tran.Timestamp = now
+	tran.ActorID = publisher.EntityID
+	tran.Status = "Success"
+	err = writeTransaction(stub, tran)
+	if err != nil {
+		return fail(err.Error())
+	}
+	err = applySwapReset(stub, tran.ClientID, tran)
+	if err != nil {
+		return fail(err.Error())
+	}
+	err = applySwapReset(stub, tran.BankID, tran)
+	if err != nil {
+		return fail(err.Error())
+	}
+	err = updateTradeState(stub, trade.TradeID, transactionID, "Trade Executed")
+	if err != nil {
+		return fail("Error while updating trade state")
+	}
+	err = stub.PutState("currentTransactionNum", []byte(strconv.Itoa(tid)))
+	if err != nil {
+		return fail("Error while writing currentTransactionNum to ledger")
+	}
+	err = emitTradeEvent(stub, newTradeEvent(tran, "Trade Executed"))
+	if err != nil {
+		return fail(err.Error())
+	}
+	return nil, nil
+}
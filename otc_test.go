@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// writes cash dividends of a symbol as numbered corporate actions
+func putDividends(stub *testStub, symbol string, amounts []float64, effective []time.Time) {
+	num := 1000
+	for i := 0; i < len(amounts); i++ {
+		num++
+		b, _ := json.Marshal(CorporateAction{ActionID: "corpAction" + strconv.Itoa(num), Type: cashDividendAction, Symbol: symbol, Amount: amounts[i], EffectiveAt: effective[i]})
+		stub.PutState("corpAction"+strconv.Itoa(num), b)
+	}
+	stub.PutState("currentCorporateActionNum", []byte(strconv.Itoa(num)))
+}
+
+func TestSwapReset(t *testing.T) {
+	start := time.Date(2026, 3, 2, 15, 0, 0, 0, time.UTC)
+	now := start.AddDate(0, 0, 48)
+	terms := Transaction{
+		StockSymbol: "IBM",
+		Quantity:    1,
+		Multiplier:  1,
+		StockRate:   400,
+		Swap:        &SwapTerms{FinancingRate: 2.5, AccrualStart: start},
+	}
+	// financing of 400 * 2.5% * 48 / 360 on a price return of 20
+	tests := []struct {
+		name      string
+		dividends []float64
+		effective []time.Time
+		spot      float64
+		want      float64
+	}{
+		{"price return less financing", nil, nil, 420, 18.6667},
+		{"dividend of the period", []float64{1}, []time.Time{start.AddDate(0, 0, 10)}, 420, 19.6667},
+		{"dividend before the period", []float64{1}, []time.Time{start.AddDate(0, 0, -10)}, 420, 18.6667},
+		{"dividend on the reset date", []float64{1, 2}, []time.Time{now, now.AddDate(0, 0, 1)}, 420, 19.6667},
+		{"client pays a price fall", nil, nil, 380, -21.3333},
+	}
+	for _, tt := range tests {
+		stub := newTestStub(now)
+		putDividends(stub, "IBM", tt.dividends, tt.effective)
+		cash, next, err := swapReset(stub, terms, tt.spot, now)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if !within(cash, tt.want, 1e-4) {
+			t.Errorf("%s: got %.4f, want %.4f", tt.name, cash, tt.want)
+		}
+		if !next.AccrualStart.Equal(now) || next.FinancingRate != 2.5 {
+			t.Errorf("%s: next period %+v does not start at the reset", tt.name, *next)
+		}
+	}
+	if !terms.Swap.AccrualStart.Equal(start) {
+		t.Errorf("reset changed the terms it was given")
+	}
+}
+
+func TestLinearPayoff(t *testing.T) {
+	forward := Transaction{Quantity: 2, Multiplier: 100, StockRate: 50, SpotPrice: 53.5}
+	if got := linearPayoff(forward); !within(got, 700, 1e-9) {
+		t.Errorf("forward: got %.4f, want 700", got)
+	}
+	swap := Transaction{Quantity: 2, Multiplier: 100, StockRate: 50, SpotPrice: 53.5, CashSettlement: -12.5, Swap: &SwapTerms{}}
+	if got := linearPayoff(swap); got != -12.5 {
+		t.Errorf("swap: got %.4f, want the cash settlement -12.5", got)
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Asian and lookback types, settled in cash from the fixings of their schedule
+const averagePrice = "AveragePrice"         // average of the fixings against the stock rate
+const averageStrike = "AverageStrike"       // last fixing against the average of the fixings
+const fixedLookback = "FixedLookback"       // highest (call) or lowest (put) fixing against the stock rate
+const floatingLookback = "FloatingLookback" // last fixing against the lowest (call) or highest (put) fixing
+
+const maxFixings = 260 // a year of daily fixings
+
+const fixingIndex = "fixing" // symbol, date YYYY-MM-DD -> Fixing
+
+// sources of a fixing price
+const fixingSpot = "Spot"   // last spot published on the date
+const fixingClose = "Close" // previous close published on the next business day, when no spot was published on the date
+
+// averaging or lookback terms of an option
+type PathTerms struct {
+	Type        string
+	FixingDates []time.Time // business days of the instrument's market, ascending
+}
+
+// official price of a symbol on a date, taken from the published market data
+type Fixing struct {
+	Symbol      string
+	Date        string // YYYY-MM-DD
+	Price       float64
+	Source      string // Spot or Close
+	PublisherID string
+	RecordedAt  time.Time
+}
+
+func pathType(s string) (string, bool) {
+	for _, p := range []string{averagePrice, averageStrike, fixedLookback, floatingLookback} {
+		if strings.EqualFold(s, p) {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// true when the strike is set by the fixings and the quoted stock rate is ignored
+func (p PathTerms) floatingStrike() bool {
+	return p.Type == averageStrike || p.Type == floatingLookback
+}
+
+// error unless the last fixing falls before the expiration date, options settle once every fixing is
+// recorded and can only be exercised before the expiration date, not on it
+func (p PathTerms) checkExpiry(settlementDate time.Time) error {
+	last := p.FixingDates[len(p.FixingDates)-1]
+	if !last.Before(settlementDate) {
+		return errors.New("Error fixing date " + last.Format("2006-01-02") + " is not before the expiration date " + settlementDate.Format("2006-01-02"))
+	}
+	return nil
+}
+
+// exotic terms of a quote request, barrier terms or a fixing schedule depending on the type, e.g.
+// {"Type":"AveragePrice","FixingDates":["2026-11-16","2026-12-16"]}
+func parseOptionTerms(stub shim.ChaincodeStubInterface, symbol string, s string) (*Barrier, *PathTerms, error) {
+	var in struct {
+		Type        string
+		FixingDates []string
+	}
+	err := json.Unmarshal([]byte(s), &in)
+	if err != nil {
+		return nil, nil, errors.New("Error invalid option terms")
+	}
+	p, ok := pathType(in.Type)
+	if !ok {
+		barrier, err := parseBarrier(s)
+		return barrier, nil, err
+	}
+	if len(in.FixingDates) == 0 || len(in.FixingDates) > maxFixings {
+		return nil, nil, errors.New("Error fixing schedule must have between 1 and 260 dates")
+	}
+	calendar, err := instrumentCalendar(stub, symbol)
+	if err != nil {
+		return nil, nil, err
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, nil, err
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	terms := PathTerms{Type: p}
+	for i := 0; i < len(in.FixingDates); i++ {
+		d, err := time.Parse("2006-01-02", in.FixingDates[i])
+		if err != nil {
+			return nil, nil, errors.New("Error invalid fixing date " + in.FixingDates[i])
+		}
+		if d.Before(today) {
+			return nil, nil, errors.New("Error fixing date " + in.FixingDates[i] + " has passed")
+		}
+		err = calendar.checkBusinessDay(d, "fixing date")
+		if err != nil {
+			return nil, nil, err
+		}
+		if i > 0 && !d.After(terms.FixingDates[i-1]) {
+			return nil, nil, errors.New("Error fixing dates must be ascending")
+		}
+		terms.FixingDates = append(terms.FixingDates, d)
+	}
+	return nil, &terms, nil
+}
+
+// recorded fixings of a symbol by date
+func readFixings(stub shim.ChaincodeStubInterface, symbol string) ([]Fixing, error) {
+	entries, err := scanIndex(stub, fixingIndex, strings.ToUpper(symbol))
+	if err != nil {
+		return nil, err
+	}
+	fixings := make([]Fixing, len(entries))
+	for i := 0; i < len(entries); i++ {
+		err = json.Unmarshal(entries[i].Value, &fixings[i])
+		if err != nil {
+			return nil, errors.New("Error while unmarshalling fixing")
+		}
+	}
+	return fixings, nil
+}
+
+// fixing of a symbol on a date, ok is false when none was recorded
+func readFixing(stub shim.ChaincodeStubInterface, symbol string, date string) (Fixing, bool, error) {
+	var fixing Fixing
+	b, err := stub.GetState(indexKey(fixingIndex, strings.ToUpper(symbol), date))
+	if err != nil {
+		return fixing, false, errors.New("Error while getting fixing from ledger")
+	}
+	if len(b) == 0 {
+		return fixing, false, nil
+	}
+	err = json.Unmarshal(b, &fixing)
+	if err != nil {
+		return fixing, false, errors.New("Error while unmarshalling fixing")
+	}
+	return fixing, true, nil
+}
+
+func writeFixing(stub shim.ChaincodeStubInterface, fixing Fixing) error {
+	b, err := json.Marshal(fixing)
+	if err != nil {
+		return errors.New("Error while marshalling fixing")
+	}
+	return putIndexEntry(stub, b, fixingIndex, strings.ToUpper(fixing.Symbol), fixing.Date)
+}
+
+// recorded prices of the schedule in order, zero where no fixing was recorded
+func scheduleFixings(stub shim.ChaincodeStubInterface, symbol string, path PathTerms) ([]float64, error) {
+	prices := make([]float64, len(path.FixingDates))
+	for i := 0; i < len(path.FixingDates); i++ {
+		fixing, ok, err := readFixing(stub, symbol, path.FixingDates[i].Format("2006-01-02"))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			prices[i] = fixing.Price
+		}
+	}
+	return prices, nil
+}
+
+// cash per share owed to the holder on exercise, fails until every fixing of the schedule is recorded
+func pathSettlement(stub shim.ChaincodeStubInterface, tran Transaction) (float64, error) {
+	prices, err := scheduleFixings(stub, tran.StockSymbol, *tran.Path)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < len(prices); i++ {
+		if prices[i] <= 0 {
+			return 0, errors.New("Error fixing of " + tran.StockSymbol + " on " + tran.Path.FixingDates[i].Format("2006-01-02") + " not recorded, cannot be exercised")
+		}
+	}
+	return pathPayoff(tran.OptionType, tran.Path.Type, prices, tran.StockRate), nil
+}
+
+// pricing function of a fixing schedule with the fixings recorded so far, the strike is bound in
+func pathModel(stub shim.ChaincodeStubInterface, symbol string, optionType string, path PathTerms, strike float64) (func(float64, float64, float64, float64) (float64, error), error) {
+	prices, err := scheduleFixings(stub, symbol, path)
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	times := make([]float64, len(path.FixingDates))
+	for i := 0; i < len(path.FixingDates); i++ {
+		times[i] = yearFraction(now, path.FixingDates[i])
+	}
+	return func(spot float64, vol float64, rate float64, t float64) (float64, error) {
+		return pathPrice(optionType, path.Type, times, prices, spot, strike, vol, rate, t)
+	}, nil
+}
+
+// price of a symbol on a business day from its market data history: the last spot published that day or,
+// when nothing was published that day, the previous close published on the next business day; prices
+// published before a later split are restated to its terms like the recorded fixings
+func historicalFixing(stub shim.ChaincodeStubInterface, symbol string, calendar Calendar, day time.Time) (float64, string, error) {
+	date := day.Format("2006-01-02")
+	next, err := calendar.addBusinessDays(day, 1)
+	if err != nil {
+		return 0, "", err
+	}
+	history, err := readMarketDataHistory(stub, symbol, date, next.Format("2006-01-02"))
+	if err != nil {
+		return 0, "", err
+	}
+	var m MarketData
+	source := fixingSpot
+	price := 0.0
+	if len(history) != 0 {
+		m = history[len(history)-1]
+		price = m.Spot
+	} else {
+		history, err = readMarketDataHistory(stub, symbol, next.Format("2006-01-02"), next.AddDate(0, 0, 1).Format("2006-01-02"))
+		if err != nil {
+			return 0, "", err
+		}
+		if len(history) == 0 {
+			return 0, "", errors.New("Error no market data of " + symbol + " published on " + date + " or the next business day")
+		}
+		m = history[0]
+		source = fixingClose
+		price = m.Close
+	}
+	factor, err := splitFactorSince(stub, symbol, m.Timestamp)
+	if err != nil {
+		return 0, "", err
+	}
+	return price * factor, source, nil
+}
+
+// used by the price publisher to record the fixing of a symbol for today, or for a past business day missed
+// at the time, from the published market data, see historicalFixing
+/*			arg 0	:	StockSymbol
+			arg 1	:	Publisher EntityID
+			arg 2	:	Fixing date YYYY-MM-DD (optional, today if left out)
+*/
+func (t *SimpleChaincode) recordFixing(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	publisher, err := getEntity(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if publisher.EntityType != "PricePublisher" {
+		return nil, errors.New("Error only an authorized price publisher can record fixings")
+	}
+	instrument, err := getInstrument(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	calendar, err := instrumentCalendar(stub, instrument.Symbol)
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	day := today
+	if len(args) == 3 {
+		day, err = time.Parse("2006-01-02", args[2])
+		if err != nil {
+			return nil, errors.New("Error invalid fixing date " + args[2])
+		}
+		if day.After(today) {
+			return nil, errors.New("Error fixing date " + args[2] + " is in the future")
+		}
+	}
+	date := day.Format("2006-01-02")
+	err = calendar.checkBusinessDay(day, "fixing date")
+	if err != nil {
+		return nil, err
+	}
+	_, ok, err := readFixing(stub, instrument.Symbol, date)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return nil, errors.New("Error fixing of " + instrument.Symbol + " on " + date + " already recorded")
+	}
+	fixing := Fixing{
+		Symbol:      instrument.Symbol,
+		Date:        date,
+		Source:      fixingSpot,
+		PublisherID: publisher.EntityID,
+		RecordedAt:  now,
+	}
+	if day.Equal(today) {
+		m, err := readMarketData(stub, instrument.Symbol)
+		if err != nil {
+			return nil, err
+		}
+		if m.Timestamp.Format("2006-01-02") != date {
+			return nil, errors.New("Error no spot of " + instrument.Symbol + " published on " + date)
+		}
+		fixing.Price = m.Spot
+	} else {
+		fixing.Price, fixing.Source, err = historicalFixing(stub, instrument.Symbol, calendar, day)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = writeFixing(stub, fixing)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+/*			arg 0	:	StockSymbol
+*/
+func (t *SimpleChaincode) getFixings(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	fixings, err := readFixings(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(fixings)
+	if err != nil {
+		return nil, errors.New("Error while marshalling fixings")
+	}
+	return b, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecordFixingBackfill(t *testing.T) {
+	cc := new(SimpleChaincode)
+	monday := time.Date(2026, 3, 2, 15, 0, 0, 0, time.UTC)
+	stub := newTestLedger(t, monday)
+	publish := func(at time.Time, spot string, close string) {
+		stub.now = at
+		_, err := cc.publishMarketData(stub, []string{"AAPL", spot, close, "0.25", entity5})
+		if err != nil {
+			t.Fatalf("publish: %v", err)
+		}
+	}
+	// nothing is published on Tuesday
+	publish(monday, "130", "128")
+	publish(monday.Add(time.Hour), "131", "128")
+	publish(monday.AddDate(0, 0, 2), "134", "132")
+	stub.now = monday.AddDate(0, 0, 3)
+	_, err := cc.applyCorporateAction(stub, []string{"Split", "AAPL", "2:1", entity4})
+	if err != nil {
+		t.Fatalf("split: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		date   string
+		price  float64
+		source string
+	}{
+		{"last spot of the day", "2026-03-02", 65.5, fixingSpot},
+		{"close published the next business day", "2026-03-03", 66, fixingClose},
+	}
+	for _, tt := range tests {
+		_, err := cc.recordFixing(stub, []string{"AAPL", entity5, tt.date})
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		fixing, ok, _ := readFixing(stub, "AAPL", tt.date)
+		if !ok || !within(fixing.Price, tt.price, 1e-9) || fixing.Source != tt.source {
+			t.Errorf("%s: got %+v, want %.2f from the %s", tt.name, fixing, tt.price, tt.source)
+		}
+	}
+
+	rejected := []struct {
+		name string
+		args []string
+	}{
+		{"fixing recorded twice", []string{"AAPL", entity5, "2026-03-02"}},
+		{"no spot published today", []string{"AAPL", entity5}},
+		{"future date", []string{"AAPL", entity5, "2026-03-06"}},
+		{"weekend", []string{"AAPL", entity5, "2026-02-28"}},
+		{"nothing published on the date or the day after", []string{"AAPL", entity5, "2026-02-26"}},
+		{"recorded by a client", []string{"AAPL", entity1, "2026-03-04"}},
+	}
+	for _, tt := range rejected {
+		_, err := cc.recordFixing(stub, tt.args)
+		if err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+
+	fixings, err := readFixings(stub, "aapl")
+	if err != nil || len(fixings) != 2 || fixings[0].Date != "2026-03-02" || fixings[1].Date != "2026-03-03" {
+		t.Errorf("got fixings %+v, %v, want those of 2 and 3 March in order", fixings, err)
+	}
+}
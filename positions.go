@@ -0,0 +1,335 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const movementIndex = "movement" // entityID, sequence number -> PositionMovement
+
+// a single change to an entity's portfolio or options
+type PositionMovement struct {
+	EntityID      string
+	Seq           int
+	TransactionID string // transaction causing the change, empty for balances set by init
+	TradeID       string
+	Timestamp     time.Time
+	Kind          string  // Stock or Option
+	Symbol        string
+	Quantity      int     // change in shares for Stock, +1 option added or -1 removed for Option
+	Option        *Option `json:",omitempty"` // option added or removed
+}
+
+type Holdings struct {
+	EntityID  string
+	AsOf      string
+	Portfolio []Stock
+	Options   []Option
+}
+
+type StockBreak struct {
+	Symbol    string
+	Ledger    int // quantity in the entity's portfolio
+	Movements int // quantity from the sum of movements
+}
+
+type Reconciliation struct {
+	EntityID       string
+	Reconciled     bool
+	StockBreaks    []StockBreak
+	MissingOptions []string // trades of options held but not found in the movements
+	ExtraOptions   []string // trades of options found in the movements but not held
+}
+
+func movementNumKey(entityID string) string {
+	return "movementNum_" + entityID
+}
+
+// identifies an option within an entity's options
+func optionKey(o Option) string {
+	return o.TradeID
+}
+
+// movements turning the positions of before into the positions of after
+func diffPositions(before Entity, after Entity) []PositionMovement {
+	var movements []PositionMovement
+	qty := make(map[string]int)
+	var symbols []string
+	for i := 0; i < len(before.Portfolio); i++ {
+		if _, ok := qty[before.Portfolio[i].Symbol]; !ok {
+			symbols = append(symbols, before.Portfolio[i].Symbol)
+		}
+		qty[before.Portfolio[i].Symbol] -= before.Portfolio[i].Quantity
+	}
+	for i := 0; i < len(after.Portfolio); i++ {
+		if _, ok := qty[after.Portfolio[i].Symbol]; !ok {
+			symbols = append(symbols, after.Portfolio[i].Symbol)
+		}
+		qty[after.Portfolio[i].Symbol] += after.Portfolio[i].Quantity
+	}
+	for i := 0; i < len(symbols); i++ {
+		if qty[symbols[i]] != 0 {
+			movements = append(movements, PositionMovement{Kind: "Stock", Symbol: symbols[i], Quantity: qty[symbols[i]]})
+		}
+	}
+
+	held := make(map[string]Option)
+	for i := 0; i < len(after.Options); i++ {
+		held[optionKey(after.Options[i])] = after.Options[i]
+	}
+	previous := make(map[string]Option)
+	for i := 0; i < len(before.Options); i++ {
+		o := before.Options[i]
+		previous[optionKey(o)] = o
+		if n, ok := held[optionKey(o)]; !ok || !sameOption(o, n) {
+			removed := o
+			movements = append(movements, PositionMovement{Kind: "Option", Symbol: o.Symbol, TradeID: o.TradeID, Quantity: -1, Option: &removed})
+		}
+	}
+	for i := 0; i < len(after.Options); i++ {
+		o := after.Options[i]
+		if p, ok := previous[optionKey(o)]; !ok || !sameOption(o, p) {
+			added := o
+			movements = append(movements, PositionMovement{Kind: "Option", Symbol: o.Symbol, TradeID: o.TradeID, Quantity: 1, Option: &added})
+		}
+	}
+	return movements
+}
+
+func sameOption(a Option, b Option) bool {
+	ab, _ := json.Marshal(a)
+	bb, _ := json.Marshal(b)
+	return string(ab) == string(bb)
+}
+
+func writeMovements(stub shim.ChaincodeStubInterface, entityID string, movements []PositionMovement, transactionID string, tradeID string) error {
+	if len(movements) == 0 {
+		return nil
+	}
+	num := 0
+	numbyte, err := stub.GetState(movementNumKey(entityID))
+	if err != nil {
+		return errors.New("Error while getting movement number from ledger")
+	}
+	if len(numbyte) != 0 {
+		num, err = strconv.Atoi(string(numbyte))
+		if err != nil {
+			return errors.New("Error while converting movement number to integer")
+		}
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(movements); i++ {
+		num++
+		m := movements[i]
+		m.EntityID = entityID
+		m.Seq = num
+		m.TransactionID = transactionID
+		if m.TradeID == "" {
+			m.TradeID = tradeID
+		}
+		m.Timestamp = now
+		b, err := json.Marshal(m)
+		if err != nil {
+			return errors.New("Error while marshalling position movement")
+		}
+		err = putIndexEntry(stub, b, movementIndex, entityID, fmt.Sprintf("%010d", num))
+		if err != nil {
+			return err
+		}
+	}
+	err = stub.PutState(movementNumKey(entityID), []byte(strconv.Itoa(num)))
+	if err != nil {
+		return errors.New("Error while writing movement number to ledger")
+	}
+	return nil
+}
+
+// records the movements between the stored state of an entity and the state about to be written
+func recordPositionChanges(stub shim.ChaincodeStubInterface, entity Entity, transactionID string, tradeID string) error {
+	stored, err := getEntity(stub, entity.EntityID)
+	if err != nil {
+		return err
+	}
+	return writeMovements(stub, entity.EntityID, diffPositions(stored, entity), transactionID, tradeID)
+}
+
+// records the movements needed for the sum of movements to match the entity, used by init
+func syncPositionMovements(stub shim.ChaincodeStubInterface, entity Entity) error {
+	movements, err := readMovements(stub, entity.EntityID)
+	if err != nil {
+		return err
+	}
+	rebuilt := applyMovements(entity.EntityID, movements)
+	return writeMovements(stub, entity.EntityID, diffPositions(rebuilt, entity), "", "")
+}
+
+func readMovements(stub shim.ChaincodeStubInterface, entityID string) ([]PositionMovement, error) {
+	entries, err := scanIndex(stub, movementIndex, entityID)
+	if err != nil {
+		return nil, err
+	}
+	movements := make([]PositionMovement, len(entries))
+	for i := 0; i < len(entries); i++ {
+		err = json.Unmarshal(entries[i].Value, &movements[i])
+		if err != nil {
+			return nil, errors.New("Error while unmarshalling position movement")
+		}
+	}
+	return movements, nil
+}
+
+// holdings resulting from a list of movements
+func applyMovements(entityID string, movements []PositionMovement) Entity {
+	entity := Entity{EntityID: entityID}
+	qty := make(map[string]int)
+	var symbols []string
+	options := make(map[string]Option)
+	var keys []string
+	for i := 0; i < len(movements); i++ {
+		m := movements[i]
+		if m.Kind == "Stock" {
+			if _, ok := qty[m.Symbol]; !ok {
+				symbols = append(symbols, m.Symbol)
+			}
+			qty[m.Symbol] += m.Quantity
+		} else if m.Option != nil {
+			k := optionKey(*m.Option)
+			if m.Quantity > 0 {
+				if _, ok := options[k]; !ok {
+					keys = append(keys, k)
+				}
+				options[k] = *m.Option
+			} else {
+				delete(options, k)
+			}
+		}
+	}
+	for i := 0; i < len(symbols); i++ {
+		if qty[symbols[i]] != 0 {
+			entity.Portfolio = append(entity.Portfolio, Stock{Symbol: symbols[i], Quantity: qty[symbols[i]]})
+		}
+	}
+	for i := 0; i < len(keys); i++ {
+		if o, ok := options[keys[i]]; ok {
+			entity.Options = append(entity.Options, o)
+			delete(options, keys[i])
+		}
+	}
+	return entity
+}
+
+func transactionNum(transactionID string) int {
+	n, _ := strconv.Atoi(strings.TrimPrefix(transactionID, "trans"))
+	return n
+}
+
+/*			arg 0	:	EntityID
+*/
+func (t *SimpleChaincode) getPositionHistory(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	movements, err := readMovements(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(movements)
+	if err != nil {
+		return nil, errors.New("Error while marshalling position movements")
+	}
+	return b, nil
+}
+
+// holdings of an entity as of the end of a date or right after a transaction
+/*			arg 0	:	EntityID
+			arg 1	:	Date YYYY-MM-DD or TransactionID e.g. trans1005
+*/
+func (t *SimpleChaincode) getPositionsAsOf(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	movements, err := readMovements(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	var upTo []PositionMovement
+	if strings.HasPrefix(args[1], "trans") {
+		num := transactionNum(args[1])
+		if num == 0 {
+			return nil, errors.New("Error invalid transaction ID " + args[1])
+		}
+		for i := 0; i < len(movements); i++ {
+			if transactionNum(movements[i].TransactionID) <= num {
+				upTo = append(upTo, movements[i])
+			}
+		}
+	} else {
+		date, err := time.Parse("2006-01-02", args[1])
+		if err != nil {
+			return nil, errors.New("Error invalid as of date")
+		}
+		end := date.AddDate(0, 0, 1)
+		for i := 0; i < len(movements); i++ {
+			if movements[i].Timestamp.Before(end) {
+				upTo = append(upTo, movements[i])
+			}
+		}
+	}
+	entity := applyMovements(args[0], upTo)
+	b, err := json.Marshal(Holdings{EntityID: args[0], AsOf: args[1], Portfolio: entity.Portfolio, Options: entity.Options})
+	if err != nil {
+		return nil, errors.New("Error while marshalling holdings")
+	}
+	return b, nil
+}
+
+// compares an entity's current portfolio and options with the sum of its movements
+/*			arg 0	:	EntityID
+*/
+func (t *SimpleChaincode) reconcilePositions(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	movements, err := readMovements(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	rebuilt := applyMovements(args[0], movements)
+	report := Reconciliation{EntityID: args[0]}
+	diff := diffPositions(rebuilt, entity)
+	for i := 0; i < len(diff); i++ {
+		d := diff[i]
+		if d.Kind == "Stock" {
+			ledger := 0
+			for j := 0; j < len(entity.Portfolio); j++ {
+				if entity.Portfolio[j].Symbol == d.Symbol {
+					ledger += entity.Portfolio[j].Quantity
+				}
+			}
+			report.StockBreaks = append(report.StockBreaks, StockBreak{Symbol: d.Symbol, Ledger: ledger, Movements: ledger - d.Quantity})
+		} else if d.Quantity > 0 {
+			report.MissingOptions = append(report.MissingOptions, d.TradeID)
+		} else {
+			report.ExtraOptions = append(report.ExtraOptions, d.TradeID)
+		}
+	}
+	report.Reconciled = len(diff) == 0
+	b, err := json.Marshal(report)
+	if err != nil {
+		return nil, errors.New("Error while marshalling reconciliation")
+	}
+	return b, nil
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// reference pricing models used to benchmark bank quotes, no ledger access in here
+
+const binomialSteps = 200    // steps used by the binomial tree for American options
+const pathSimulations = 2000 // antithetic path pairs simulated for Asian and lookback options
+const pathSeed = 20160801    // fixed seed of the path simulation, prices must agree across peers
+
+type OptionValuation struct {
+	StockSymbol    string
+	OptionType     string // Call/ Put
+	ExerciseStyle  string // European/ American
+	StockRate      float64
+	SettlementDate time.Time
+	Spot           float64
+	Volatility     float64
+	Rate           float64
+	TimeToExpiry   float64 // in years
+	Price          float64
+}
+
+// standard normal cumulative distribution function
+func normCDF(x float64) float64 {
+	return 0.5 * math.Erfc(-x/math.Sqrt2)
+}
+
+// standard normal probability density function
+func normPDF(x float64) float64 {
+	return math.Exp(-0.5*x*x) / math.Sqrt(2*math.Pi)
+}
+
+// time to expiry in years, zero once the settlement date has passed
+func yearFraction(from time.Time, to time.Time) float64 {
+	if !to.After(from) {
+		return 0
+	}
+	return to.Sub(from).Hours() / (365 * 24)
+}
+
+func intrinsicValue(optionType string, spot float64, strike float64) float64 {
+	if strings.ToLower(optionType) == "call" {
+		return math.Max(spot-strike, 0)
+	}
+	return math.Max(strike-spot, 0)
+}
+
+func validatePricingInputs(optionType string, spot float64, strike float64, vol float64) error {
+	if ot := strings.ToLower(optionType); ot != "call" && ot != "put" {
+		return errors.New("Error invalid option type " + optionType)
+	}
+	if spot <= 0 {
+		return errors.New("Error spot price must be positive")
+	}
+	if strike <= 0 {
+		return errors.New("Error stock rate must be positive")
+	}
+	if vol <= 0 {
+		return errors.New("Error volatility must be positive")
+	}
+	return nil
+}
+
+// Black-Scholes price of a European option, t is time to expiry in years
+func blackScholesPrice(optionType string, spot float64, strike float64, vol float64, rate float64, t float64) (float64, error) {
+	err := validatePricingInputs(optionType, spot, strike, vol)
+	if err != nil {
+		return 0, err
+	}
+	if t <= 0 {
+		return intrinsicValue(optionType, spot, strike), nil
+	}
+	d1 := (math.Log(spot/strike) + (rate+vol*vol/2)*t) / (vol * math.Sqrt(t))
+	d2 := d1 - vol*math.Sqrt(t)
+	if strings.ToLower(optionType) == "call" {
+		return spot*normCDF(d1) - strike*math.Exp(-rate*t)*normCDF(d2), nil
+	}
+	return strike*math.Exp(-rate*t)*normCDF(-d2) - spot*normCDF(-d1), nil
+}
+
+// Cox-Ross-Rubinstein binomial tree price of an American option
+func binomialPrice(optionType string, spot float64, strike float64, vol float64, rate float64, t float64, steps int) (float64, error) {
+	err := validatePricingInputs(optionType, spot, strike, vol)
+	if err != nil {
+		return 0, err
+	}
+	if t <= 0 {
+		return intrinsicValue(optionType, spot, strike), nil
+	}
+	if steps < 1 {
+		return 0, errors.New("Error binomial tree needs at least one step")
+	}
+	dt := t / float64(steps)
+	up := math.Exp(vol * math.Sqrt(dt))
+	down := 1 / up
+	disc := math.Exp(-rate * dt)
+	p := (math.Exp(rate*dt) - down) / (up - down)
+	if p < 0 || p > 1 {
+		return 0, errors.New("Error binomial tree is unstable for the given inputs")
+	}
+	// option values at expiry
+	values := make([]float64, steps+1)
+	for i := 0; i <= steps; i++ {
+		values[i] = intrinsicValue(optionType, spot*math.Pow(up, float64(steps-i))*math.Pow(down, float64(i)), strike)
+	}
+	// step back through the tree allowing early exercise at every node
+	for n := steps - 1; n >= 0; n-- {
+		for i := 0; i <= n; i++ {
+			cont := disc * (p*values[i] + (1-p)*values[i+1])
+			exercise := intrinsicValue(optionType, spot*math.Pow(up, float64(n-i))*math.Pow(down, float64(i)), strike)
+			values[i] = math.Max(cont, exercise)
+		}
+	}
+	return values[0], nil
+}
+
+// fair value using Black-Scholes for European and the binomial tree for American exercise
+func modelPrice(exerciseStyle string, optionType string, spot float64, strike float64, vol float64, rate float64, t float64) (float64, error) {
+	switch strings.ToLower(exerciseStyle) {
+	case "", "european":
+		return blackScholesPrice(optionType, spot, strike, vol, rate, t)
+	case "american":
+		return binomialPrice(optionType, spot, strike, vol, rate, t, binomialSteps)
+	}
+	return 0, errors.New("Error invalid exercise style " + exerciseStyle)
+}
+
+type Greeks struct {
+	Delta float64
+	Gamma float64
+	Vega  float64 // per 1% change in volatility
+	Theta float64 // per calendar day
+	Rho   float64 // per 1% change in rate
+}
+
+func (g Greeks) add(o Greeks) Greeks {
+	return Greeks{Delta: g.Delta + o.Delta, Gamma: g.Gamma + o.Gamma, Vega: g.Vega + o.Vega, Theta: g.Theta + o.Theta, Rho: g.Rho + o.Rho}
+}
+
+func (g Greeks) scale(q float64) Greeks {
+	return Greeks{Delta: g.Delta * q, Gamma: g.Gamma * q, Vega: g.Vega * q, Theta: g.Theta * q, Rho: g.Rho * q}
+}
+
+// Black-Scholes sensitivities of a single European option
+func blackScholesGreeks(optionType string, spot float64, strike float64, vol float64, rate float64, t float64) (Greeks, error) {
+	err := validatePricingInputs(optionType, spot, strike, vol)
+	if err != nil {
+		return Greeks{}, err
+	}
+	call := strings.ToLower(optionType) == "call"
+	if t <= 0 {
+		// expired, only the delta of the intrinsic value is left
+		if call && spot > strike {
+			return Greeks{Delta: 1}, nil
+		} else if !call && spot < strike {
+			return Greeks{Delta: -1}, nil
+		}
+		return Greeks{}, nil
+	}
+	sqrtT := math.Sqrt(t)
+	d1 := (math.Log(spot/strike) + (rate+vol*vol/2)*t) / (vol * sqrtT)
+	d2 := d1 - vol*sqrtT
+	disc := strike * math.Exp(-rate*t)
+	g := Greeks{
+		Gamma: normPDF(d1) / (spot * vol * sqrtT),
+		Vega:  spot * normPDF(d1) * sqrtT / 100,
+	}
+	if call {
+		g.Delta = normCDF(d1)
+		g.Theta = (-spot*normPDF(d1)*vol/(2*sqrtT) - rate*disc*normCDF(d2)) / 365
+		g.Rho = disc * t * normCDF(d2) / 100
+	} else {
+		g.Delta = normCDF(d1) - 1
+		g.Theta = (-spot*normPDF(d1)*vol/(2*sqrtT) + rate*disc*normCDF(-d2)) / 365
+		g.Rho = -disc * t * normCDF(-d2) / 100
+	}
+	return g, nil
+}
+
+// price of a European barrier option not hit yet, closed form of Reiner and Rubinstein for a barrier monitored
+// continuously (Hull, section 26.9, and Haug for the rebates); knock-outs pay the rebate when hit and knock-ins
+// at expiry when never hit, the knock-in and knock-out without rebates add up to the vanilla option
+func barrierPrice(optionType string, barrierType string, level float64, rebate float64, spot float64, strike float64, vol float64, rate float64, t float64) (float64, error) {
+	err := validatePricingInputs(optionType, spot, strike, vol)
+	if err != nil {
+		return 0, err
+	}
+	if level <= 0 || rebate < 0 {
+		return 0, errors.New("Error invalid barrier")
+	}
+	upBarrier := barrierType == upAndOut || barrierType == upAndIn
+	knockIn := barrierType == upAndIn || barrierType == downAndIn
+	if (upBarrier && spot >= level) || (!upBarrier && spot <= level) {
+		if knockIn {
+			return blackScholesPrice(optionType, spot, strike, vol, rate, t)
+		}
+		return rebate, nil
+	}
+	if t <= 0 {
+		if knockIn {
+			return rebate, nil
+		}
+		return intrinsicValue(optionType, spot, strike), nil
+	}
+	vanilla, err := blackScholesPrice(optionType, spot, strike, vol, rate, t)
+	if err != nil {
+		return 0, err
+	}
+	call := strings.ToLower(optionType) == "call"
+	sd := vol * math.Sqrt(t)
+	lambda := (rate + vol*vol/2) / (vol * vol)
+	disc := strike * math.Exp(-rate*t)
+	// reflected spot and strike terms of the barrier
+	a := spot * math.Pow(level/spot, 2*lambda)
+	b := disc * math.Pow(level/spot, 2*lambda-2)
+	y := math.Log(level*level/(spot*strike))/sd + lambda*sd
+	x1 := math.Log(spot/level)/sd + lambda*sd
+	y1 := math.Log(level/spot)/sd + lambda*sd
+	// knock-in without rebate, the knock-out is the vanilla option less it
+	var in float64
+	switch {
+	case call && !upBarrier && level <= strike:
+		in = a*normCDF(y) - b*normCDF(y-sd)
+	case call && !upBarrier:
+		in = vanilla - (spot*normCDF(x1) - disc*normCDF(x1-sd) - a*normCDF(y1) + b*normCDF(y1-sd))
+	case call && level <= strike:
+		// the call is out of the money until the barrier is hit
+		in = vanilla
+	case call:
+		in = spot*normCDF(x1) - disc*normCDF(x1-sd) - a*(normCDF(-y)-normCDF(-y1)) + b*(normCDF(-y+sd)-normCDF(-y1+sd))
+	case upBarrier && level >= strike:
+		in = -a*normCDF(-y) + b*normCDF(-y+sd)
+	case upBarrier:
+		in = vanilla - (-spot*normCDF(-x1) + disc*normCDF(-x1+sd) + a*normCDF(-y1) - b*normCDF(-y1+sd))
+	case level >= strike:
+		// the put is out of the money until the barrier is hit
+		in = vanilla
+	default:
+		in = -spot*normCDF(-x1) + disc*normCDF(-x1+sd) + a*(normCDF(y)-normCDF(y1)) - b*(normCDF(y-sd)-normCDF(y1-sd))
+	}
+	in = math.Min(math.Max(in, 0), vanilla)
+	// eta is 1 for down barriers and -1 for up barriers
+	eta := 1.0
+	if upBarrier {
+		eta = -1
+	}
+	if knockIn {
+		// rebate paid at expiry when the barrier is never hit
+		notHit := math.Exp(-rate*t) * (normCDF(eta*(x1-sd)) - math.Pow(level/spot, 2*lambda-2)*normCDF(eta*(y1-sd)))
+		return in + rebate*math.Max(notHit, 0), nil
+	}
+	// rebate paid when the barrier is hit
+	hit := math.Pow(level/spot, 2*lambda-1)*normCDF(eta*y1) + spot/level*normCDF(eta*(y1-2*lambda*sd))
+	return vanilla - in + rebate*math.Max(hit, 0), nil
+}
+
+// sensitivities of a barrier option by bumping the inputs of barrierPrice, in the units of blackScholesGreeks
+func barrierGreeks(optionType string, barrierType string, level float64, rebate float64, spot float64, strike float64, vol float64, rate float64, t float64) (Greeks, error) {
+	return bumpGreeks(func(s float64, v float64, r float64, tt float64) (float64, error) {
+		return barrierPrice(optionType, barrierType, level, rebate, s, strike, v, r, tt)
+	}, spot, vol, rate, t)
+}
+
+// greeks of a pricing model by finite differences, for models without closed form greeks
+func bumpGreeks(price func(spot float64, vol float64, rate float64, t float64) (float64, error), spot float64, vol float64, rate float64, t float64) (Greeks, error) {
+	p0, err := price(spot, vol, rate, t)
+	if err != nil {
+		return Greeks{}, err
+	}
+	h := spot * 0.01
+	pu, err := price(spot+h, vol, rate, t)
+	if err != nil {
+		return Greeks{}, err
+	}
+	pd, err := price(spot-h, vol, rate, t)
+	if err != nil {
+		return Greeks{}, err
+	}
+	pv, err := price(spot, vol+0.01, rate, t)
+	if err != nil {
+		return Greeks{}, err
+	}
+	pr, err := price(spot, vol, rate+0.01, t)
+	if err != nil {
+		return Greeks{}, err
+	}
+	pt, err := price(spot, vol, rate, math.Max(t-1.0/365, 0))
+	if err != nil {
+		return Greeks{}, err
+	}
+	return Greeks{
+		Delta: (pu - pd) / (2 * h),
+		Gamma: (pu - 2*p0 + pd) / (h * h),
+		Vega:  pv - p0,
+		Theta: pt - p0,
+		Rho:   pr - p0,
+	}, nil
+}
+
+// payoff per share of an Asian or lookback option from its fixings in schedule order
+func pathPayoff(optionType string, pathType string, fixings []float64, strike float64) float64 {
+	if len(fixings) == 0 {
+		return 0
+	}
+	sum, low, high := 0.0, fixings[0], fixings[0]
+	for i := 0; i < len(fixings); i++ {
+		sum += fixings[i]
+		low = math.Min(low, fixings[i])
+		high = math.Max(high, fixings[i])
+	}
+	average := sum / float64(len(fixings))
+	last := fixings[len(fixings)-1]
+	call := strings.ToLower(optionType) == "call"
+	switch pathType {
+	case averagePrice:
+		return intrinsicValue(optionType, average, strike)
+	case averageStrike:
+		return intrinsicValue(optionType, last, average)
+	case fixedLookback:
+		if call {
+			return intrinsicValue(optionType, high, strike)
+		}
+		return intrinsicValue(optionType, low, strike)
+	case floatingLookback:
+		if call {
+			return last - low
+		}
+		return high - last
+	}
+	return 0
+}
+
+// Monte Carlo price of an Asian or lookback option, times are the years from now to each fixing and
+// fixings the recorded prices, zero where not recorded yet. The generator is seeded so every peer
+// computes the same price.
+func pathPrice(optionType string, pathType string, times []float64, fixings []float64, spot float64, strike float64, vol float64, rate float64, t float64) (float64, error) {
+	err := validatePricingInputs(optionType, spot, strike, vol)
+	if err != nil {
+		return 0, err
+	}
+	if len(times) == 0 || len(times) != len(fixings) {
+		return 0, errors.New("Error invalid fixing schedule")
+	}
+	rng := rand.New(rand.NewSource(pathSeed))
+	up := make([]float64, len(times))
+	down := make([]float64, len(times))
+	sum := 0.0
+	for n := 0; n < pathSimulations; n++ {
+		// antithetic pair of paths from the same draws
+		su, sd, last := spot, spot, 0.0
+		for i := 0; i < len(times); i++ {
+			if fixings[i] > 0 {
+				up[i], down[i] = fixings[i], fixings[i]
+				continue
+			}
+			if times[i] <= 0 {
+				// fixing date passed without a fixing, the spot stands in
+				up[i], down[i] = spot, spot
+				continue
+			}
+			dt := times[i] - last
+			z := rng.NormFloat64()
+			drift := (rate - 0.5*vol*vol) * dt
+			su = su * math.Exp(drift+vol*math.Sqrt(dt)*z)
+			sd = sd * math.Exp(drift-vol*math.Sqrt(dt)*z)
+			up[i], down[i] = su, sd
+			last = times[i]
+		}
+		sum += pathPayoff(optionType, pathType, up, strike) + pathPayoff(optionType, pathType, down, strike)
+	}
+	return math.Exp(-rate*t) * sum / float64(2*pathSimulations), nil
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func within(got float64, want float64, tol float64) bool {
+	return math.Abs(got-want) <= tol
+}
+
+func TestBlackScholesPrice(t *testing.T) {
+	tests := []struct {
+		name       string
+		optionType string
+		spot       float64
+		strike     float64
+		vol        float64
+		rate       float64
+		t          float64
+		want       float64
+	}{
+		{"at the money call", "call", 100, 100, 0.2, 0.05, 1, 10.4506},
+		{"at the money put", "put", 100, 100, 0.2, 0.05, 1, 5.5735},
+		// Hull, Options, Futures and Other Derivatives, example 15.6
+		{"Hull call", "Call", 42, 40, 0.2, 0.1, 0.5, 4.7594},
+		{"Hull put", "Put", 42, 40, 0.2, 0.1, 0.5, 0.8086},
+		{"expired call in the money", "call", 110, 100, 0.2, 0.05, 0, 10},
+		{"expired put out of the money", "put", 110, 100, 0.2, 0.05, 0, 0},
+	}
+	for _, tt := range tests {
+		got, err := blackScholesPrice(tt.optionType, tt.spot, tt.strike, tt.vol, tt.rate, tt.t)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if !within(got, tt.want, 1e-4) {
+			t.Errorf("%s: got %.4f, want %.4f", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBlackScholesPutCallParity(t *testing.T) {
+	spot, strike, vol, rate, tte := 95.0, 105.0, 0.3, 0.03, 0.75
+	call, _ := blackScholesPrice("call", spot, strike, vol, rate, tte)
+	put, _ := blackScholesPrice("put", spot, strike, vol, rate, tte)
+	if !within(call-put, spot-strike*math.Exp(-rate*tte), 1e-9) {
+		t.Errorf("call %.6f less put %.6f does not match the forward", call, put)
+	}
+}
+
+func TestPricingInputErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		optionType string
+		spot       float64
+		strike     float64
+		vol        float64
+	}{
+		{"unknown type", "straddle", 100, 100, 0.2},
+		{"zero spot", "call", 0, 100, 0.2},
+		{"negative strike", "put", 100, -1, 0.2},
+		{"zero volatility", "call", 100, 100, 0},
+	}
+	for _, tt := range tests {
+		_, err := blackScholesPrice(tt.optionType, tt.spot, tt.strike, tt.vol, 0.05, 1)
+		if err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+		_, err = binomialPrice(tt.optionType, tt.spot, tt.strike, tt.vol, 0.05, 1, 10)
+		if err == nil {
+			t.Errorf("%s: expected an error from the binomial tree", tt.name)
+		}
+	}
+	_, err := modelPrice("Bermudan", "call", 100, 100, 0.2, 0.05, 1)
+	if err == nil {
+		t.Errorf("expected an error for an unknown exercise style")
+	}
+}
+
+func TestBinomialPrice(t *testing.T) {
+	euroCall, _ := blackScholesPrice("call", 100, 100, 0.2, 0.05, 1)
+	tests := []struct {
+		name       string
+		optionType string
+		spot       float64
+		strike     float64
+		vol        float64
+		rate       float64
+		t          float64
+		steps      int
+		want       float64
+		tol        float64
+	}{
+		// Hull, example 21.1, five step tree of an American put
+		{"Hull five steps", "put", 50, 50, 0.4, 0.1, 5.0 / 12, 5, 4.49, 0.005},
+		// the same put on a fine tree converges to its American value
+		{"Hull converged", "put", 50, 50, 0.4, 0.1, 5.0 / 12, binomialSteps, 4.28, 0.01},
+		// early exercise of a call on a stock without dividends is never optimal
+		{"American call", "call", 100, 100, 0.2, 0.05, 1, binomialSteps, euroCall, 0.02},
+		{"expired", "put", 90, 100, 0.2, 0.05, 0, binomialSteps, 10, 1e-9},
+	}
+	for _, tt := range tests {
+		got, err := binomialPrice(tt.optionType, tt.spot, tt.strike, tt.vol, tt.rate, tt.t, tt.steps)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if !within(got, tt.want, tt.tol) {
+			t.Errorf("%s: got %.4f, want %.4f", tt.name, got, tt.want)
+		}
+	}
+	american, _ := modelPrice("American", "put", 100, 100, 0.2, 0.05, 1)
+	european, _ := modelPrice("European", "put", 100, 100, 0.2, 0.05, 1)
+	if american <= european {
+		t.Errorf("American put %.4f is not worth more than the European put %.4f", american, european)
+	}
+}
+
+func TestBlackScholesGreeks(t *testing.T) {
+	tests := []struct {
+		name       string
+		optionType string
+		spot       float64
+		strike     float64
+		vol        float64
+		rate       float64
+		t          float64
+		want       Greeks
+		tol        float64
+	}{
+		// Hull, example 19.1 and the following sections, vega and rho per 1% and theta per calendar day
+		{"Hull call", "call", 49, 50, 0.2, 0.05, 0.3846, Greeks{Delta: 0.522, Gamma: 0.066, Vega: 0.121, Theta: -4.31 / 365, Rho: 0.0891}, 1e-3},
+		{"at the money call", "call", 100, 100, 0.2, 0.05, 1, Greeks{Delta: 0.63683, Gamma: 0.018762, Vega: 0.37524, Theta: -0.017573, Rho: 0.53232}, 1e-5},
+		{"at the money put", "put", 100, 100, 0.2, 0.05, 1, Greeks{Delta: -0.36317, Gamma: 0.018762, Vega: 0.37524, Theta: -0.0045423, Rho: -0.41890}, 1e-5},
+		{"expired call in the money", "call", 110, 100, 0.2, 0.05, 0, Greeks{Delta: 1}, 0},
+		{"expired put out of the money", "put", 110, 100, 0.2, 0.05, 0, Greeks{}, 0},
+	}
+	for _, tt := range tests {
+		got, err := blackScholesGreeks(tt.optionType, tt.spot, tt.strike, tt.vol, tt.rate, tt.t)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if !within(got.Delta, tt.want.Delta, tt.tol) || !within(got.Gamma, tt.want.Gamma, tt.tol) || !within(got.Vega, tt.want.Vega, tt.tol) || !within(got.Theta, tt.want.Theta, tt.tol) || !within(got.Rho, tt.want.Rho, tt.tol) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBumpGreeksMatchClosedForm(t *testing.T) {
+	price := func(spot float64, vol float64, rate float64, t float64) (float64, error) {
+		return blackScholesPrice("call", spot, 100, vol, rate, t)
+	}
+	got, err := bumpGreeks(price, 100, 0.2, 0.05, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := blackScholesGreeks("call", 100, 100, 0.2, 0.05, 1)
+	if !within(got.Delta, want.Delta, 1e-3) || !within(got.Gamma, want.Gamma, 1e-3) || !within(got.Vega, want.Vega, 1e-2) || !within(got.Theta, want.Theta, 1e-3) || !within(got.Rho, want.Rho, 1e-2) {
+		t.Errorf("bumped %+v, closed form %+v", got, want)
+	}
+}
+
+func TestBarrierPrice(t *testing.T) {
+	vanillaCall, _ := blackScholesPrice("call", 100, 100, 0.2, 0.05, 1)
+	tests := []struct {
+		name        string
+		optionType  string
+		barrierType string
+		level       float64
+		rebate      float64
+		spot        float64
+		strike      float64
+		t           float64
+		want        float64
+	}{
+		// Reiner and Rubinstein as given in Haug, The Complete Guide to Option Pricing Formulas, section 4.17.1,
+		// at a rate of 5% without dividends. Haug's table takes a cost of carry below the rate, which
+		// barrierPrice has no input for, so the values come from a separate implementation of the
+		// formulas that reproduces that table
+		{"down-and-out call", "call", downAndOut, 90, 0, 100, 100, 1, 8.6655},
+		{"down-and-in call", "call", downAndIn, 90, 0, 100, 100, 1, 1.7851},
+		{"down-and-out call barrier above the strike", "call", downAndOut, 95, 0, 100, 90, 1, 7.8529},
+		{"up-and-out call", "call", upAndOut, 120, 0, 100, 100, 1, 1.1761},
+		{"up-and-in call", "call", upAndIn, 120, 0, 100, 100, 1, 9.2745},
+		{"up-and-out put", "put", upAndOut, 110, 0, 100, 100, 1, 4.1982},
+		{"up-and-in put", "put", upAndIn, 110, 0, 100, 100, 1, 1.3753},
+		{"down-and-out put", "put", downAndOut, 90, 0, 100, 100, 1, 0.1512},
+		{"down-and-in put", "put", downAndIn, 90, 0, 100, 100, 1, 5.4223},
+		{"down-and-out call with rebate", "call", downAndOut, 90, 3, 100, 100, 1, 10.2907},
+		{"down-and-in call with rebate", "call", downAndIn, 90, 3, 100, 100, 1, 3.0670},
+		{"up-and-out put with rebate", "put", upAndOut, 110, 3, 100, 100, 1, 6.2021},
+		{"up-and-in put with rebate", "put", upAndIn, 110, 3, 100, 100, 1, 2.2934},
+		// hit barriers leave the vanilla option or the rebate
+		{"knocked in", "call", downAndIn, 90, 3, 90, 100, 1, 5.0912},
+		{"knocked out", "call", upAndOut, 120, 3, 125, 100, 1, 3},
+		{"expired knock-in never hit", "call", downAndIn, 90, 3, 120, 100, 0, 3},
+		{"expired knock-out never hit", "call", downAndOut, 90, 3, 120, 100, 0, 20},
+		{"knock-in call of a barrier never reached", "call", upAndIn, 95, 0, 100, 100, 1, vanillaCall},
+	}
+	for _, tt := range tests {
+		got, err := barrierPrice(tt.optionType, tt.barrierType, tt.level, tt.rebate, tt.spot, tt.strike, 0.2, 0.05, tt.t)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if !within(got, tt.want, 1e-4) {
+			t.Errorf("%s: got %.4f, want %.4f", tt.name, got, tt.want)
+		}
+	}
+	_, err := barrierPrice("call", downAndOut, 0, 0, 100, 100, 0.2, 0.05, 1)
+	if err == nil {
+		t.Errorf("expected an error for a zero barrier level")
+	}
+}
+
+func TestBarrierInOutParity(t *testing.T) {
+	pairs := [][2]string{{downAndIn, downAndOut}, {upAndIn, upAndOut}}
+	for _, optionType := range []string{"call", "put"} {
+		vanilla, _ := blackScholesPrice(optionType, 100, 100, 0.25, 0.03, 0.5)
+		for _, pair := range pairs {
+			level := 85.0
+			if pair[0] == upAndIn {
+				level = 115
+			}
+			in, _ := barrierPrice(optionType, pair[0], level, 0, 100, 100, 0.25, 0.03, 0.5)
+			out, _ := barrierPrice(optionType, pair[1], level, 0, 100, 100, 0.25, 0.03, 0.5)
+			if !within(in+out, vanilla, 1e-9) {
+				t.Errorf("%s %s: knock-in %.6f and knock-out %.6f do not add up to %.6f", optionType, pair[0], in, out, vanilla)
+			}
+		}
+	}
+}
+
+func TestBarrierGreeks(t *testing.T) {
+	// a knock-out barrier far from the spot leaves the greeks of the vanilla option
+	got, err := barrierGreeks("call", downAndOut, 1, 0, 100, 100, 0.2, 0.05, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := blackScholesGreeks("call", 100, 100, 0.2, 0.05, 1)
+	if !within(got.Delta, want.Delta, 1e-3) || !within(got.Gamma, want.Gamma, 1e-3) || !within(got.Vega, want.Vega, 1e-2) || !within(got.Theta, want.Theta, 1e-3) || !within(got.Rho, want.Rho, 1e-2) {
+		t.Errorf("far barrier %+v, vanilla %+v", got, want)
+	}
+	// an up-and-out call loses value as the spot nears the barrier
+	got, err = barrierGreeks("call", upAndOut, 110, 0, 105, 100, 0.2, 0.05, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Delta >= 0 || got.Vega >= 0 {
+		t.Errorf("up-and-out call near its barrier has delta %.4f and vega %.4f, want both negative", got.Delta, got.Vega)
+	}
+}
+
+func TestPathPayoff(t *testing.T) {
+	fixings := []float64{100, 110, 90, 120}
+	tests := []struct {
+		optionType string
+		pathType   string
+		strike     float64
+		want       float64
+	}{
+		// average 105, lowest 90, highest 120, last 120
+		{"call", averagePrice, 100, 5},
+		{"put", averagePrice, 110, 5},
+		{"put", averagePrice, 100, 0},
+		{"call", averageStrike, 0, 15},
+		{"put", averageStrike, 0, 0},
+		{"call", fixedLookback, 100, 20},
+		{"put", fixedLookback, 100, 10},
+		{"call", floatingLookback, 0, 30},
+		{"put", floatingLookback, 0, 0},
+	}
+	for _, tt := range tests {
+		got := pathPayoff(tt.optionType, tt.pathType, fixings, tt.strike)
+		if !within(got, tt.want, 1e-9) {
+			t.Errorf("%s %s strike %.0f: got %.4f, want %.4f", tt.pathType, tt.optionType, tt.strike, got, tt.want)
+		}
+	}
+	if got := pathPayoff("call", averagePrice, nil, 100); got != 0 {
+		t.Errorf("payoff without fixings is %.4f, want 0", got)
+	}
+}
+
+func TestPathPrice(t *testing.T) {
+	// every fixing recorded, the discounted payoff is known
+	got, err := pathPrice("call", floatingLookback, []float64{-0.1, -0.05}, []float64{90, 120}, 115, 1, 0.2, 0.05, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 30 * math.Exp(-0.05*0.5); !within(got, want, 1e-9) {
+		t.Errorf("recorded fixings: got %.6f, want %.6f", got, want)
+	}
+	// a single fixing at expiry averages nothing, the simulation converges on Black-Scholes
+	got, err = pathPrice("call", averagePrice, []float64{1}, []float64{0}, 100, 100, 0.2, 0.05, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := blackScholesPrice("call", 100, 100, 0.2, 0.05, 1); !within(got, want, 0.3) {
+		t.Errorf("single fixing: got %.4f, want %.4f", got, want)
+	}
+	// Hull, Options, Futures and Other Derivatives, section 26.13, average price call of 5.62 on continuous averaging
+	times := make([]float64, maxFixings)
+	for i := 0; i < len(times); i++ {
+		times[i] = float64(i+1) / float64(len(times))
+	}
+	asian, err := pathPrice("call", averagePrice, times, make([]float64, len(times)), 50, 50, 0.4, 0.1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !within(asian, 5.62, 0.1) {
+		t.Errorf("Hull average price call: got %.4f, want 5.62", asian)
+	}
+	// the same seed gives the same price on every peer
+	again, _ := pathPrice("call", averagePrice, []float64{1}, []float64{0}, 100, 100, 0.2, 0.05, 1)
+	if again != got {
+		t.Errorf("simulation not deterministic, %.6f then %.6f", got, again)
+	}
+	_, err = pathPrice("call", averagePrice, []float64{1}, nil, 100, 100, 0.2, 0.05, 1)
+	if err == nil {
+		t.Errorf("expected an error for a schedule without fixings")
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// behaviour of a product type quoted through the rfq workflow, looked up by the option type of its
+// transactions; the lifecycle invokes only move transactions and trade states, products decide the rest
+type Product interface {
+	Name() string
+	// checks and completes the terms of a quote request, terms are the optional arguments after the client
+	ValidateRequest(stub shim.ChaincodeStubInterface, tran *Transaction, terms []string) error
+	// checks a bank's response against the product's rules and completes its terms, the response carries
+	// the option price and stock rate entered by the bank
+	ValidateQuote(stub shim.ChaincodeStubInterface, quote *Transaction) error
+	// checks and completes the terms of an execution
+	Execute(stub shim.ChaincodeStubInterface, tran *Transaction) error
+	// exercises or settles executed terms, moving shares between client and bank or setting the cash owed;
+	// the ledger holds no cash balances, cash amounts are recorded on the transaction and paid outside it
+	Settle(stub shim.ChaincodeStubInterface, tran *Transaction, client *Entity, bank *Entity) error
+	// model value per share of held terms
+	Value(stub shim.ChaincodeStubInterface, exerciseStyle string, o Option, spot float64, vol float64, rate float64, t float64) (float64, error)
+	// greeks per share of held terms, in the units of blackScholesGreeks
+	Greeks(stub shim.ChaincodeStubInterface, o Option, spot float64, vol float64, rate float64, t float64) (Greeks, error)
+}
+
+// product types known to the chaincode, new products only need to be added here
+var products = []Product{callOption, putOption, forwardContract{}, totalReturnSwap{}}
+
+func productFor(optionType string) (Product, error) {
+	for i := 0; i < len(products); i++ {
+		if strings.EqualFold(products[i].Name(), optionType) {
+			return products[i], nil
+		}
+	}
+	return nil, errors.New("Error unknown product type " + optionType)
+}
+
+// terms of a transaction as held in a portfolio
+func heldTerms(tran Transaction) Option {
+	return Option{
+		Symbol:         tran.StockSymbol,
+		Quantity:       tran.Quantity,
+		Multiplier:     tran.Multiplier,
+		OptionType:     tran.OptionType,
+		StockRate:      tran.StockRate,
+		SettlementDate: tran.SettlementDate,
+		OptionPrice:    tran.OptionPrice,
+		TradeID:        tran.TradeID,
+		StrategyID:     tran.StrategyID,
+		Side:           tran.Side,
+		Barrier:        tran.Barrier,
+		Path:           tran.Path,
+		Basket:         tran.Basket,
+		Swap:           tran.Swap,
+	}
+}
+
+// model price of held terms, valued by their product
+func termsPrice(stub shim.ChaincodeStubInterface, exerciseStyle string, o Option, spot float64, vol float64, rate float64, t float64) (float64, error) {
+	product, err := productFor(o.OptionType)
+	if err != nil {
+		return 0, err
+	}
+	return product.Value(stub, exerciseStyle, o, spot, vol, rate, t)
+}
+
+func termsGreeks(stub shim.ChaincodeStubInterface, o Option, spot float64, vol float64, rate float64, t float64) (Greeks, error) {
+	product, err := productFor(o.OptionType)
+	if err != nil {
+		return Greeks{}, err
+	}
+	return product.Greeks(stub, o, spot, vol, rate, t)
+}
+
+// rejects quoted prices off the tick size of the underlying
+func checkQuoteTicks(stub shim.ChaincodeStubInterface, quote Transaction, prices ...float64) error {
+	instrument, err := underlyingInstrument(stub, quote)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(prices); i++ {
+		if !onTick(instrument, prices[i]) {
+			return errors.New("Error quote rejected: prices must be multiples of the tick size " + strconv.FormatFloat(instrument.TickSize, 'f', -1, 64))
+		}
+	}
+	return nil
+}
+
+// calls and puts, barriers, fixing schedules and baskets are terms of the option
+type vanillaOption struct {
+	name          string
+	buyerReceives bool // the buyer receives the shares on exercise of physically settled options
+}
+
+var callOption = vanillaOption{name: "call", buyerReceives: true}
+var putOption = vanillaOption{name: "put"}
+
+func (p vanillaOption) Name() string {
+	return p.name
+}
+
+func (p vanillaOption) ValidateRequest(stub shim.ChaincodeStubInterface, tran *Transaction, terms []string) error {
+	// baskets are named by the client and made of registered instruments
+	if len(terms) > 0 {
+		basket, err := parseBasket(stub, tran.StockSymbol, terms[0])
+		if err != nil {
+			return err
+		}
+		tran.Basket = basket
+	}
+	// symbol must be a registered tradable instrument, stored in its canonical form
+	instrument, err := validateUnderlyingOrder(stub, *tran)
+	if err != nil {
+		return err
+	}
+	tran.StockSymbol = instrument.Symbol
+	tran.Multiplier = instrument.contractMultiplier()
+	if len(terms) == 0 || tran.Basket != nil {
+		return nil
+	}
+	// barrier options must not be hit at the current spot
+	tran.Barrier, tran.Path, err = parseOptionTerms(stub, tran.StockSymbol, terms[0])
+	if err != nil {
+		return err
+	}
+	return checkBarrierNotHit(stub, tran.StockSymbol, tran.Barrier)
+}
+
+func (p vanillaOption) ValidateQuote(stub shim.ChaincodeStubInterface, quote *Transaction) error {
+	// expiration date must follow the calendar of the instrument's market
+	calendar, err := underlyingCalendar(stub, *quote)
+	if err != nil {
+		return err
+	}
+	err = calendar.validateExpiry(quote.SettlementDate)
+	if err != nil {
+		return err
+	}
+	// check quote against the sanity bands of the symbol
+	err = validateQuote(stub, quote.StockSymbol, quote.OptionType, quote.Barrier, quote.Path, quote.OptionPrice, quote.StockRate, quote.SettlementDate)
+	if err != nil {
+		return err
+	}
+	if quote.Path != nil {
+		err = quote.Path.checkExpiry(quote.SettlementDate)
+		if err != nil {
+			return err
+		}
+	}
+	err = checkBarrierNotHit(stub, quote.StockSymbol, quote.Barrier)
+	if err != nil {
+		return err
+	}
+	return checkQuoteTicks(stub, *quote, quote.OptionPrice, quote.StockRate)
+}
+
+func (p vanillaOption) Execute(stub shim.ChaincodeStubInterface, tran *Transaction) error {
+	return checkBarrierNotHit(stub, tran.StockSymbol, tran.Barrier)
+}
+
+func (p vanillaOption) Settle(stub shim.ChaincodeStubInterface, tran *Transaction, client *Entity, bank *Entity) error {
+	// knock-in options can only be exercised once knocked in
+	if tran.Barrier != nil && !tran.Barrier.exercisable() {
+		return errors.New("Error barrier option not knocked in, cannot be exercised")
+	}
+	delivered := shares(tran.Quantity, tran.Multiplier)
+	// Asian and lookback options settle in cash once every fixing is recorded
+	if tran.Path != nil {
+		perShare, err := pathSettlement(stub, *tran)
+		if err != nil {
+			return err
+		}
+		tran.CashSettlement = perShare * float64(delivered)
+		return nil
+	}
+	// basket options settle in cash at the basket price, which needs fresh prices of every component
+	if tran.Basket != nil {
+		m, err := underlyingMarketData(stub, tran.StockSymbol, tran.Basket)
+		if err != nil {
+			return err
+		}
+		tran.CashSettlement = intrinsicValue(tran.OptionType, m.Spot, tran.StockRate) * float64(delivered)
+		return nil
+	}
+	// the client receives the shares on calls it bought and puts it sold
+	if p.buyerReceives != (tran.Side == sellSide) {
+		return deliverShares(bank, client, tran.StockSymbol, delivered)
+	}
+	return deliverShares(client, bank, tran.StockSymbol, delivered)
+}
+
+// live barriers and fixing schedules use their own models and all others the vanilla one
+func (p vanillaOption) Value(stub shim.ChaincodeStubInterface, exerciseStyle string, o Option, spot float64, vol float64, rate float64, t float64) (float64, error) {
+	if o.Path != nil {
+		price, err := pathModel(stub, o.Symbol, o.OptionType, *o.Path, o.StockRate)
+		if err != nil {
+			return 0, err
+		}
+		return price(spot, vol, rate, t)
+	}
+	if o.Barrier != nil && o.Barrier.Status == barrierLive {
+		return barrierPrice(o.OptionType, o.Barrier.Type, o.Barrier.Level, o.Barrier.Rebate, spot, o.StockRate, vol, rate, t)
+	}
+	return modelPrice(exerciseStyle, o.OptionType, spot, o.StockRate, vol, rate, t)
+}
+
+func (p vanillaOption) Greeks(stub shim.ChaincodeStubInterface, o Option, spot float64, vol float64, rate float64, t float64) (Greeks, error) {
+	if o.Path != nil {
+		price, err := pathModel(stub, o.Symbol, o.OptionType, *o.Path, o.StockRate)
+		if err != nil {
+			return Greeks{}, err
+		}
+		return bumpGreeks(price, spot, vol, rate, t)
+	}
+	if o.Barrier != nil && o.Barrier.Status == barrierLive {
+		return barrierGreeks(o.OptionType, o.Barrier.Type, o.Barrier.Level, o.Barrier.Rebate, spot, o.StockRate, vol, rate, t)
+	}
+	return blackScholesGreeks(o.OptionType, spot, o.StockRate, vol, rate, t)
+}
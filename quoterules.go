@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// validation bands applied to bank quotes of a symbol, a zero band is not checked
+type QuoteRule struct {
+	Symbol              string
+	StrikeBandPct       float64 // max distance of StockRate from the published spot, in percent
+	PremiumTolerancePct float64 // max distance of OptionPrice from the model price, in percent
+	Rate                float64 // risk free rate used for the model price
+	ExerciseStyle       string  // European/ American, model used for the model price
+}
+
+func quoteRuleKey(symbol string) string {
+	return "quoteRule_" + strings.ToUpper(symbol)
+}
+
+// quote rule of a symbol, ok is false when none has been configured
+func quoteRuleFor(stub shim.ChaincodeStubInterface, symbol string) (QuoteRule, bool, error) {
+	var rule QuoteRule
+	b, err := stub.GetState(quoteRuleKey(symbol))
+	if err != nil {
+		return rule, false, errors.New("Error while getting quote rule from ledger")
+	}
+	if len(b) == 0 {
+		return rule, false, nil
+	}
+	err = json.Unmarshal(b, &rule)
+	if err != nil {
+		return rule, false, errors.New("Error while unmarshalling quote rule")
+	}
+	return rule, true, nil
+}
+
+// checks a bank quote against the rules of its symbol, the error holds the rejection reason
+func validateQuote(stub shim.ChaincodeStubInterface, symbol string, optionType string, barrier *Barrier, path *PathTerms, price float64, strike float64, settlementDate time.Time) error {
+	if price <= 0 {
+		return errors.New("Error quote rejected: option price must be positive")
+	}
+	if strike <= 0 {
+		return errors.New("Error quote rejected: stock rate must be positive")
+	}
+	rule, ok, err := quoteRuleFor(stub, symbol)
+	if err != nil {
+		return err
+	}
+	if !ok || (rule.StrikeBandPct <= 0 && rule.PremiumTolerancePct <= 0) {
+		return nil
+	}
+	m, err := getFreshMarketData(stub, symbol)
+	if err != nil {
+		return errors.New("Error quote rejected: cannot check reference price, " + err.Error())
+	}
+	err = checkStrikeBand(rule, m.Spot, strike)
+	if err != nil {
+		return err
+	}
+	if rule.PremiumTolerancePct > 0 {
+		now, err := txTime(stub)
+		if err != nil {
+			return err
+		}
+		fair, err := termsPrice(stub, rule.ExerciseStyle, Option{Symbol: symbol, OptionType: optionType, StockRate: strike, Barrier: barrier, Path: path}, m.Spot, m.Volatility, rule.Rate, yearFraction(now, settlementDate))
+		if err != nil {
+			return errors.New("Error quote rejected: cannot compute model price, " + err.Error())
+		}
+		if fair <= 0 {
+			return nil
+		}
+		distance := math.Abs(price-fair) / fair * 100
+		if distance > rule.PremiumTolerancePct {
+			return fmt.Errorf("Error quote rejected: option price %.2f is %.2f%% away from model price %.2f, tolerance is %.2f%%", price, distance, fair, rule.PremiumTolerancePct)
+		}
+	}
+	return nil
+}
+
+func checkStrikeBand(rule QuoteRule, spot float64, strike float64) error {
+	if rule.StrikeBandPct <= 0 {
+		return nil
+	}
+	distance := math.Abs(strike-spot) / spot * 100
+	if distance > rule.StrikeBandPct {
+		return fmt.Errorf("Error quote rejected: stock rate %.2f is %.2f%% away from spot %.2f, band is %.2f%%", strike, distance, spot, rule.StrikeBandPct)
+	}
+	return nil
+}
+
+// used by the regulatory body to configure quote validation for a symbol
+/*			arg 0	:	StockSymbol
+			arg 1	:	Strike band in percent of spot (0 to disable)
+			arg 2	:	Premium tolerance in percent of model price (0 to disable)
+			arg 3	:	Risk free rate
+			arg 4	:	European/ American
+			arg 5	:	RegBody EntityID
+*/
+func (t *SimpleChaincode) setQuoteRule(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 6 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	entitybyte, err := stub.GetState(args[5])
+	if err != nil {
+		return nil, errors.New("Error while getting entity info from ledger")
+	}
+	var entity Entity
+	err = json.Unmarshal(entitybyte, &entity)
+	if err != nil {
+		return nil, errors.New("Error while unmarshalling entity data")
+	}
+	if entity.EntityType != "RegBody" {
+		return nil, errors.New("Error only Regulatory Body can configure quote rules")
+	}
+	band, err := strconv.ParseFloat(args[1], 64)
+	if err != nil || band < 0 {
+		return nil, errors.New("Error invalid strike band")
+	}
+	tolerance, err := strconv.ParseFloat(args[2], 64)
+	if err != nil || tolerance < 0 {
+		return nil, errors.New("Error invalid premium tolerance")
+	}
+	rate, err := strconv.ParseFloat(args[3], 64)
+	if err != nil {
+		return nil, errors.New("Error invalid risk free rate")
+	}
+	if s := strings.ToLower(args[4]); s != "european" && s != "american" {
+		return nil, errors.New("Error invalid exercise style " + args[4])
+	}
+	rule := QuoteRule{
+		Symbol:              strings.ToUpper(args[0]),
+		StrikeBandPct:       band,
+		PremiumTolerancePct: tolerance,
+		Rate:                rate,
+		ExerciseStyle:       args[4],
+	}
+	b, err := json.Marshal(rule)
+	if err != nil {
+		return nil, errors.New("Error while marshalling quote rule")
+	}
+	err = stub.PutState(quoteRuleKey(rule.Symbol), b)
+	if err != nil {
+		return nil, errors.New("Error while writing quote rule to ledger")
+	}
+	return nil, nil
+}
+
+/*			arg 0	:	StockSymbol
+*/
+func (t *SimpleChaincode) getQuoteRule(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	rule, ok, err := quoteRuleFor(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("Error no quote rule configured for " + args[0])
+	}
+	b, err := json.Marshal(rule)
+	if err != nil {
+		return nil, errors.New("Error while marshalling quote rule")
+	}
+	return b, nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// contracts of executed, not yet settled trades of one symbol and option type
+type OpenInterest struct {
+	Symbol     string
+	OptionType string
+	Trades     int
+	Contracts  int
+	Notional   float64 // shares x strike
+}
+
+// open exposure of a bank or a client
+type CounterpartyExposure struct {
+	EntityID  string
+	Trades    int
+	Contracts int
+	Notional  float64
+	Share     float64 // fraction of the total open notional
+}
+
+// trades executed on one day
+type DailyVolume struct {
+	Date      string // YYYY-MM-DD
+	Trades    int
+	Contracts int
+	Premium   float64
+}
+
+type RegulatorReport struct {
+	Timestamp           time.Time
+	TotalTrades         int
+	OpenNotional        float64
+	OpenInterest        []OpenInterest
+	BankExposure        []CounterpartyExposure
+	ClientConcentration []CounterpartyExposure
+	Volume              []DailyVolume // oldest first
+	StatusCounts        map[string]int
+}
+
+// execute transaction of a trade, false if the trade was never executed
+func executeTransaction(stub shim.ChaincodeStubInterface, trade Trade) (Transaction, bool, error) {
+	var tran Transaction
+	for i := 0; i < len(trade.TransactionHistory); i++ {
+		if trade.TransactionHistory[i] == "" {
+			continue
+		}
+		tranbyte, err := stub.GetState(trade.TransactionHistory[i])
+		if err != nil {
+			return tran, false, errors.New("Error while getting transaction from ledger")
+		}
+		err = json.Unmarshal(tranbyte, &tran)
+		if err != nil {
+			return tran, false, errors.New("Error while unmarshalling transaction data")
+		}
+		if tran.TransactionType == "Execute" {
+			return tran, true, nil
+		}
+	}
+	return Transaction{}, false, nil
+}
+
+// latest terms of a trade, the last transaction of its history
+func currentTerms(stub shim.ChaincodeStubInterface, trade Trade) (Transaction, error) {
+	var tran Transaction
+	tranbyte, err := stub.GetState(trade.TransactionHistory[len(trade.TransactionHistory)-1])
+	if err != nil {
+		return tran, errors.New("Error while getting transaction from ledger")
+	}
+	err = json.Unmarshal(tranbyte, &tran)
+	if err != nil {
+		return tran, errors.New("Error while unmarshalling transaction data")
+	}
+	return tran, nil
+}
+
+func addExposure(exposures map[string]*CounterpartyExposure, entityID string, contracts int, notional float64) {
+	e, ok := exposures[entityID]
+	if !ok {
+		e = &CounterpartyExposure{EntityID: entityID}
+		exposures[entityID] = e
+	}
+	e.Trades++
+	e.Contracts += contracts
+	e.Notional += notional
+}
+
+type byNotionalDesc []CounterpartyExposure
+
+func (a byNotionalDesc) Len() int      { return len(a) }
+func (a byNotionalDesc) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byNotionalDesc) Less(i, j int) bool {
+	if a[i].Notional != a[j].Notional {
+		return a[i].Notional > a[j].Notional
+	}
+	return a[i].EntityID < a[j].EntityID
+}
+
+// exposures sorted by notional, largest first
+func sortedExposures(exposures map[string]*CounterpartyExposure, total float64) []CounterpartyExposure {
+	list := []CounterpartyExposure{}
+	for _, e := range exposures {
+		if total > 0 {
+			e.Share = e.Notional / total
+		}
+		list = append(list, *e)
+	}
+	sort.Sort(byNotionalDesc(list))
+	return list
+}
+
+// aggregate exposure over all trades, regulatory body only
+/*			arg 0	:	EntityID of the regulatory body
+*/
+func (t *SimpleChaincode) getRegulatorReport(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if entity.EntityType != "RegBody" {
+		return nil, errors.New("Error only Regulatory Body can access the regulator report")
+	}
+	tradeIDs, err := visibleTradeIDs(stub, entity)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	report := RegulatorReport{Timestamp: now, StatusCounts: make(map[string]int)}
+	interest := make(map[string]*OpenInterest)
+	var interestKeys []string
+	banks := make(map[string]*CounterpartyExposure)
+	clients := make(map[string]*CounterpartyExposure)
+	volume := make(map[string]*DailyVolume)
+	var days []string
+	for i := 0; i < len(tradeIDs); i++ {
+		tradebyte, err := stub.GetState(tradeIDs[i])
+		if err != nil {
+			return nil, errors.New("Error while getting trade info from ledger")
+		}
+		var trade Trade
+		err = json.Unmarshal(tradebyte, &trade)
+		if err != nil {
+			return nil, errors.New("Error while unmarshalling trade data")
+		}
+		report.TotalTrades++
+		report.StatusCounts[trade.Status]++
+
+		tran, executed, err := executeTransaction(stub, trade)
+		if err != nil {
+			return nil, err
+		}
+		if !executed {
+			continue
+		}
+		// execute transactions written before timestamps were recorded fall back to the rfq time
+		executedAt := tran.Timestamp
+		if executedAt.IsZero() {
+			executedAt = trade.CreatedAt
+		}
+		day := executedAt.Format("2006-01-02")
+		v, ok := volume[day]
+		if !ok {
+			v = &DailyVolume{Date: day}
+			volume[day] = v
+			days = append(days, day)
+		}
+		v.Trades++
+		v.Contracts += tran.Quantity
+		v.Premium += tran.OptionPrice * float64(shares(tran.Quantity, tran.Multiplier))
+
+		if trade.Status != "Trade Executed" {
+			continue
+		}
+		// open trades count on their terms in force, adjusted by any corporate action since execution
+		tran, err = currentTerms(stub, trade)
+		if err != nil {
+			return nil, err
+		}
+		notional := tran.StockRate * float64(shares(tran.Quantity, tran.Multiplier))
+		// a basket counts on each of its components by weight
+		optionType := strings.ToLower(tran.OptionType)
+		parts := splitExposure(tran.StockSymbol, tran.Basket, tran.Quantity, notional)
+		for j := 0; j < len(parts); j++ {
+			symbol := strings.ToUpper(parts[j].Symbol)
+			key := symbol + "_" + optionType
+			oi, ok := interest[key]
+			if !ok {
+				oi = &OpenInterest{Symbol: symbol, OptionType: optionType}
+				interest[key] = oi
+				interestKeys = append(interestKeys, key)
+			}
+			oi.Trades++
+			oi.Contracts += parts[j].Contracts
+			oi.Notional += parts[j].Notional
+		}
+		report.OpenNotional += notional
+		addExposure(banks, tran.BankID, tran.Quantity, notional)
+		addExposure(clients, tran.ClientID, tran.Quantity, notional)
+	}
+
+	sort.Strings(interestKeys)
+	report.OpenInterest = []OpenInterest{}
+	for i := 0; i < len(interestKeys); i++ {
+		report.OpenInterest = append(report.OpenInterest, *interest[interestKeys[i]])
+	}
+	report.BankExposure = sortedExposures(banks, report.OpenNotional)
+	report.ClientConcentration = sortedExposures(clients, report.OpenNotional)
+	sort.Strings(days)
+	report.Volume = []DailyVolume{}
+	for i := 0; i < len(days); i++ {
+		report.Volume = append(report.Volume, *volume[days[i]])
+	}
+
+	b, err := json.Marshal(report)
+	if err != nil {
+		return nil, errors.New("Error while marshalling regulator report")
+	}
+	return b, nil
+}
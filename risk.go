@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+type MarketInput struct {
+	Spot       float64
+	Volatility float64
+}
+
+type OptionRisk struct {
+	TradeID        string
+	Symbol         string
+	OptionType     string
+	Quantity       int // signed contracts, positive for long and negative for short positions
+	StockRate      float64
+	SettlementDate time.Time
+	Value          float64 // model value of the whole position
+	Greeks         Greeks  // sensitivities of the whole position
+}
+
+type RiskReport struct {
+	EntityID string
+	Rate     float64
+	Options  []OptionRisk
+	BySymbol map[string]Greeks
+	Total    Greeks
+}
+
+// clients buy options from banks, so clients are long and banks short
+func positionSign(entity Entity) int {
+	if entity.EntityType == "Bank" {
+		return -1
+	}
+	return 1
+}
+
+// sign of an option held by an entity, options a client sold in a strategy are held by the bank
+func optionSign(entity Entity, o Option) int {
+	if o.Side == sellSide {
+		return -positionSign(entity)
+	}
+	return positionSign(entity)
+}
+
+// greeks of every option held by an entity aggregated per symbol and for the entity
+/*			arg 0	:	EntityID
+			arg 1	:	market data as JSON, e.g. {"GOOGL":{"Spot":810.5,"Volatility":0.25}}
+						symbols left out are taken from the published market data
+			arg 2	:	Risk free rate
+*/
+func (t *SimpleChaincode) getRiskReport(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	entitybyte, err := stub.GetState(args[0])
+	if err != nil {
+		return nil, errors.New("Error while getting entity info from ledger")
+	}
+	var entity Entity
+	err = json.Unmarshal(entitybyte, &entity)
+	if err != nil {
+		return nil, errors.New("Error while unmarshalling entity data")
+	}
+	market := make(map[string]MarketInput)
+	if args[1] != "" {
+		err = json.Unmarshal([]byte(args[1]), &market)
+		if err != nil {
+			return nil, errors.New("Error while unmarshalling market data")
+		}
+	}
+	rate, err := strconv.ParseFloat(args[2], 64)
+	if err != nil {
+		return nil, errors.New("Error invalid risk free rate")
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	report := RiskReport{EntityID: entity.EntityID, Rate: rate, BySymbol: make(map[string]Greeks)}
+	for i := 0; i < len(entity.Options); i++ {
+		o := entity.Options[i]
+		m, ok := market[o.Symbol]
+		if !ok {
+			published, err := underlyingMarketData(stub, o.Symbol, o.Basket)
+			if err != nil {
+				return nil, err
+			}
+			m = MarketInput{Spot: published.Spot, Volatility: published.Volatility}
+			// basket names are chosen per trade, never cached
+			if o.Basket == nil {
+				market[o.Symbol] = m
+			}
+		}
+		tte := yearFraction(now, o.SettlementDate)
+		price, err := termsPrice(stub, "", o, m.Spot, m.Volatility, rate, tte)
+		if err != nil {
+			return nil, err
+		}
+		g, err := termsGreeks(stub, o, m.Spot, m.Volatility, rate, tte)
+		if err != nil {
+			return nil, err
+		}
+		qty := optionSign(entity, o) * o.Quantity
+		r := OptionRisk{
+			TradeID:        o.TradeID,
+			Symbol:         o.Symbol,
+			OptionType:     o.OptionType,
+			Quantity:       qty,
+			StockRate:      o.StockRate,
+			SettlementDate: o.SettlementDate,
+			Value:          price * float64(shares(qty, o.Multiplier)),
+			Greeks:         g.scale(float64(shares(qty, o.Multiplier))),
+		}
+		report.Options = append(report.Options, r)
+		report.BySymbol[o.Symbol] = report.BySymbol[o.Symbol].add(r.Greeks)
+		report.Total = report.Total.add(r.Greeks)
+	}
+	b, err := json.Marshal(report)
+	if err != nil {
+		return nil, errors.New("Error while marshalling risk report")
+	}
+	return b, nil
+}
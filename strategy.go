@@ -0,0 +1,929 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// strategy types
+const spreadStrategy = "Spread"
+const straddleStrategy = "Straddle"
+const strangleStrategy = "Strangle"
+const collarStrategy = "Collar"
+const customStrategy = "Custom"
+
+// leg sides, seen from the client
+const buySide = "Buy"
+const sellSide = "Sell"
+
+const maxStrategyLegs = 4
+
+// option leg of a strategy request
+type Leg struct {
+	OptionType  string
+	StockSymbol string
+	Quantity    int    // contracts, a multiple of the instrument's lot size
+	Side        string // Buy when the client holds the option, Sell when the bank does
+}
+
+// bank terms of a leg, OptionPrice is left out when the strategy is quoted at a net premium
+type LegQuote struct {
+	StockRate   float64
+	OptionPrice float64
+}
+
+// a bank's quote of all legs of a strategy
+type StrategyQuote struct {
+	QuoteID    string // Response TransactionID of the first leg, selected by the client on execution
+	BankID     string
+	NetPremium float64  // premium the client pays for the package, negative when it receives premium
+	Responses  []string // Response TransactionIDs in leg order
+	Timestamp  time.Time
+}
+
+// legs quoted and executed together as one package
+type Strategy struct {
+	StrategyID   string
+	StrategyType string
+	ClientID     string
+	BankID       string   // bank the strategy was executed with
+	Legs         []string // TradeIDs in leg order
+	Quotes       []StrategyQuote
+	CreatedAt    time.Time
+	Status       string // Quote requested, Responded, Trade Executed or Quote Cancelled, shared by all legs
+}
+
+// strategy IDs are handed to the lifecycle invokes in place of a TradeID
+func isStrategyID(id string) bool {
+	return strings.HasPrefix(id, "strategy")
+}
+
+func strategyType(s string) (string, error) {
+	for _, k := range []string{spreadStrategy, straddleStrategy, strangleStrategy, collarStrategy, customStrategy} {
+		if strings.EqualFold(s, k) {
+			return k, nil
+		}
+	}
+	return "", errors.New("Error invalid strategy type " + s)
+}
+
+// sign of the premium the client pays for a leg
+func sideSign(side string) float64 {
+	if side == sellSide {
+		return -1
+	}
+	return 1
+}
+
+func readStrategy(stub shim.ChaincodeStubInterface, strategyID string) (Strategy, error) {
+	var strategy Strategy
+	b, err := stub.GetState(strategyID)
+	if err != nil {
+		return strategy, errors.New("Error while getting strategy from ledger")
+	}
+	if len(b) == 0 {
+		return strategy, errors.New("Error unknown strategy " + strategyID)
+	}
+	err = json.Unmarshal(b, &strategy)
+	if err != nil {
+		return strategy, errors.New("Error while unmarshalling strategy")
+	}
+	return strategy, nil
+}
+
+func writeStrategy(stub shim.ChaincodeStubInterface, strategy Strategy) error {
+	b, err := json.Marshal(strategy)
+	if err != nil {
+		return errors.New("Error while marshalling strategy")
+	}
+	err = stub.PutState(strategy.StrategyID, b)
+	if err != nil {
+		return errors.New("Error while writing strategy to ledger")
+	}
+	return nil
+}
+
+// legs of a request with normalized option types and sides
+func parseLegs(s string) ([]Leg, error) {
+	var legs []Leg
+	err := json.Unmarshal([]byte(s), &legs)
+	if err != nil {
+		return nil, errors.New("Error invalid strategy legs")
+	}
+	if len(legs) < 2 || len(legs) > maxStrategyLegs {
+		return nil, fmt.Errorf("Error a strategy needs 2 to %d legs", maxStrategyLegs)
+	}
+	for i := 0; i < len(legs); i++ {
+		legs[i].OptionType = strings.ToLower(legs[i].OptionType)
+		if legs[i].OptionType != "call" && legs[i].OptionType != "put" {
+			return nil, fmt.Errorf("Error invalid option type of leg %d", i+1)
+		}
+		switch strings.ToLower(legs[i].Side) {
+		case "", "buy":
+			legs[i].Side = buySide
+		case "sell":
+			legs[i].Side = sellSide
+		default:
+			return nil, fmt.Errorf("Error invalid side of leg %d", i+1)
+		}
+	}
+	return legs, nil
+}
+
+// checks the legs have the shape of the strategy type, custom strategies take any legs
+func validateStrategyLegs(kind string, legs []Leg) error {
+	if kind == customStrategy {
+		return nil
+	}
+	if len(legs) != 2 {
+		return errors.New("Error a " + strings.ToLower(kind) + " has 2 legs")
+	}
+	a, b := legs[0], legs[1]
+	if !strings.EqualFold(a.StockSymbol, b.StockSymbol) || a.Quantity != b.Quantity {
+		return errors.New("Error legs of a " + strings.ToLower(kind) + " must be on the same symbol and quantity")
+	}
+	switch kind {
+	case spreadStrategy:
+		if a.OptionType != b.OptionType || a.Side == b.Side {
+			return errors.New("Error a spread buys and sells the same option type")
+		}
+	case straddleStrategy, strangleStrategy:
+		if a.OptionType == b.OptionType || a.Side != b.Side {
+			return errors.New("Error a " + strings.ToLower(kind) + " buys or sells a call and a put")
+		}
+	case collarStrategy:
+		if a.OptionType == b.OptionType || a.Side == b.Side {
+			return errors.New("Error a collar buys a put and sells a call")
+		}
+		for i := 0; i < 2; i++ {
+			if (legs[i].OptionType == "put") != (legs[i].Side == buySide) {
+				return errors.New("Error a collar buys a put and sells a call")
+			}
+		}
+	}
+	return nil
+}
+
+// checks the strikes a bank quoted against the strategy type
+func validateStrategyStrikes(kind string, legs []Leg, quotes []LegQuote) error {
+	if kind == customStrategy {
+		return nil
+	}
+	call, put := quotes[0].StockRate, quotes[1].StockRate
+	if legs[0].OptionType == "put" {
+		call, put = put, call
+	}
+	switch kind {
+	case spreadStrategy:
+		if quotes[0].StockRate == quotes[1].StockRate {
+			return errors.New("Error quote rejected: spread legs need different stock rates")
+		}
+	case straddleStrategy:
+		if call != put {
+			return errors.New("Error quote rejected: straddle legs need the same stock rate")
+		}
+	case strangleStrategy, collarStrategy:
+		if put >= call {
+			return errors.New("Error quote rejected: " + strings.ToLower(kind) + " needs a put stock rate below the call stock rate")
+		}
+	}
+	return nil
+}
+
+// splits a net premium over the legs, the sold legs at their model prices as of now and the bought legs
+// at whatever makes up the net, shared in proportion to their model prices; a net of zero prices the bought
+// legs at the value of the sold ones. When every leg is sold they share the net in the same way
+func allocateNetPremium(stub shim.ChaincodeStubInterface, requests []Transaction, quotes []LegQuote, settlementDate time.Time, net float64, now time.Time) error {
+	fair := make([]float64, len(requests))
+	solved := sellSide
+	for i := 0; i < len(requests); i++ {
+		m, err := getFreshMarketData(stub, requests[i].StockSymbol)
+		if err != nil {
+			return errors.New("Error net premium cannot be split over the legs, " + err.Error())
+		}
+		rule, _, err := quoteRuleFor(stub, requests[i].StockSymbol)
+		if err != nil {
+			return err
+		}
+		fair[i], err = modelPrice(rule.ExerciseStyle, requests[i].OptionType, m.Spot, quotes[i].StockRate, m.Volatility, rule.Rate, yearFraction(now, settlementDate))
+		if err != nil {
+			return err
+		}
+		if requests[i].Side == buySide {
+			solved = buySide
+		}
+	}
+	held := 0.0  // net premium of the legs priced at model
+	value := 0.0 // model value of the legs making up the rest
+	units := 0.0 // their shares
+	for i := 0; i < len(requests); i++ {
+		n := float64(shares(requests[i].Quantity, requests[i].Multiplier))
+		if requests[i].Side == solved {
+			value += fair[i] * n
+			units += n
+		} else {
+			held += sideSign(requests[i].Side) * fair[i] * n
+		}
+	}
+	rest := (net - held) * sideSign(solved)
+	if rest < 0 {
+		return fmt.Errorf("Error quote rejected: net premium %.2f against %.2f of the legs at model leaves a negative premium on the %s legs", net, held, strings.ToLower(solved))
+	}
+	for i := 0; i < len(quotes); i++ {
+		switch {
+		case requests[i].Side != solved:
+			quotes[i].OptionPrice = fair[i]
+		case value > 0:
+			quotes[i].OptionPrice = fair[i] * rest / value
+		default:
+			// worthless at model, the rest is shared per share
+			quotes[i].OptionPrice = rest / units
+		}
+	}
+	return nil
+}
+
+// request transactions of the legs of a strategy in leg order
+func strategyRequests(stub shim.ChaincodeStubInterface, strategy Strategy) ([]Transaction, error) {
+	requests := make([]Transaction, len(strategy.Legs))
+	for i := 0; i < len(strategy.Legs); i++ {
+		tradebyte, err := stub.GetState(strategy.Legs[i])
+		if err != nil {
+			return nil, errors.New("Error while getting trade info from ledger")
+		}
+		var trade Trade
+		err = json.Unmarshal(tradebyte, &trade)
+		if err != nil {
+			return nil, errors.New("Error while unmarshalling trade data")
+		}
+		tranbyte, err := stub.GetState(trade.TransactionHistory[0])
+		if err != nil {
+			return nil, errors.New("Error while reading quote request transaction from ledger")
+		}
+		err = json.Unmarshal(tranbyte, &requests[i])
+		if err != nil {
+			return nil, errors.New("Error while unmarshalling quote request data")
+		}
+	}
+	return requests, nil
+}
+
+// contracts and notional per symbol of a set of leg transactions, symbols in leg order
+func exposureBySymbol(legs []Transaction, rate func(Transaction) float64) ([]string, map[string]int, map[string]float64) {
+	var symbols []string
+	contracts := make(map[string]int)
+	notional := make(map[string]float64)
+	for i := 0; i < len(legs); i++ {
+		s := legs[i].StockSymbol
+		if _, ok := contracts[s]; !ok {
+			symbols = append(symbols, s)
+		}
+		contracts[s] += legs[i].Quantity
+		notional[s] += rate(legs[i]) * float64(shares(legs[i].Quantity, legs[i].Multiplier))
+	}
+	return symbols, contracts, notional
+}
+
+// used by client to request one quote for all legs of a strategy, called by requestForQuote
+/*			arg 0	:	Spread/ Straddle/ Strangle/ Collar/ Custom
+			arg 1	:	Legs as JSON, e.g. [{"OptionType":"call","StockSymbol":"AAPL","Quantity":10,"Side":"Buy"},
+						{"OptionType":"call","StockSymbol":"AAPL","Quantity":10,"Side":"Sell"}]
+			arg 2	:	ClientID
+*/
+func (t *SimpleChaincode) requestStrategyQuote(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	ctidByte, err := stub.GetState("currentTransactionNum")
+	if err != nil {
+		return nil, errors.New("Error while getting currentTransactionNum from ledger")
+	}
+	tid, err := strconv.Atoi(string(ctidByte))
+	if err != nil {
+		return nil, errors.New("Error while converting ctidByte to integer")
+	}
+	transactionID := "trans" + strconv.Itoa(tid+1)
+	fail := func(msg string) ([]byte, error) {
+		_ = updateTransactionStatus(stub, transactionID, msg)
+		return nil, nil
+	}
+
+	kind, err := strategyType(args[0])
+	if err != nil {
+		return fail(err.Error())
+	}
+	legs, err := parseLegs(args[1])
+	if err != nil {
+		return fail(err.Error())
+	}
+	err = validateStrategyLegs(kind, legs)
+	if err != nil {
+		return fail(err.Error())
+	}
+	client, err := getEntity(stub, args[2])
+	if err != nil {
+		return fail(err.Error())
+	}
+	ctidByte, err = stub.GetState("currentTradeNum")
+	if err != nil {
+		return fail("Error while getting currentTradeNum from ledger")
+	}
+	tradeNum, err := strconv.Atoi(string(ctidByte))
+	if err != nil {
+		return fail("Error while converting ctidByte to integer")
+	}
+	strategyNum := 1000
+	numbyte, err := stub.GetState("currentStrategyNum")
+	if err != nil {
+		return fail("Error while getting currentStrategyNum from ledger")
+	}
+	if len(numbyte) != 0 {
+		strategyNum, err = strconv.Atoi(string(numbyte))
+		if err != nil {
+			return fail("Error while converting currentStrategyNum to integer")
+		}
+	}
+	strategyNum++
+	now, err := txTime(stub)
+	if err != nil {
+		return fail(err.Error())
+	}
+	strategy := Strategy{StrategyID: "strategy" + strconv.Itoa(strategyNum), StrategyType: kind, ClientID: client.EntityID, CreatedAt: now, Status: "Quote requested"}
+
+	// every leg is checked before anything is written
+	requests := make([]Transaction, len(legs))
+	for i := 0; i < len(legs); i++ {
+		instrument, err := validateInstrumentOrder(stub, legs[i].StockSymbol, legs[i].Quantity)
+		if err != nil {
+			return fail(err.Error())
+		}
+		err = checkHalts(stub, instrument.Symbol, client.EntityID)
+		if err != nil {
+			return fail(err.Error())
+		}
+		requests[i] = Transaction{
+			TransactionID:   "trans" + strconv.Itoa(tid+1+i),
+			TradeID:         "trade" + strconv.Itoa(tradeNum+1+i),
+			StrategyID:      strategy.StrategyID,
+			TransactionType: "Request",
+			OptionType:      legs[i].OptionType,
+			Side:            legs[i].Side,
+			ClientID:        client.EntityID,
+			StockSymbol:     instrument.Symbol,
+			Quantity:        legs[i].Quantity,
+			Multiplier:      instrument.contractMultiplier(),
+			Timestamp:       now,
+			ActorID:         client.EntityID,
+			Status:          "Success",
+		}
+		strategy.Legs = append(strategy.Legs, requests[i].TradeID)
+	}
+	// limits apply to the contracts of all legs on a symbol, notional is estimated at the published spot if any
+	symbols, contracts, notional := exposureBySymbol(requests, func(tran Transaction) float64 {
+		if m, err := getFreshMarketData(stub, tran.StockSymbol); err == nil {
+			return m.Spot
+		}
+		return 0
+	})
+	for i := 0; i < len(symbols); i++ {
+		err = checkPositionLimits(stub, client, symbols[i], contracts[symbols[i]], notional[symbols[i]], transactionID, strategy.Legs[0], "Request")
+		if err != nil {
+			return fail(err.Error())
+		}
+	}
+
+	for i := 0; i < len(requests); i++ {
+		tr := Trade{
+			TradeID:    requests[i].TradeID,
+			StrategyID: strategy.StrategyID,
+			Symbol:     requests[i].StockSymbol,
+			Quantity:   requests[i].Quantity,
+			TradeType:  requests[i].OptionType,
+			Side:       requests[i].Side,
+			ClientID:   client.EntityID,
+			CreatedAt:  now,
+		}
+		err = writeTransaction(stub, requests[i])
+		if err != nil {
+			return fail(err.Error())
+		}
+		b, err := json.Marshal(tr)
+		if err != nil {
+			return fail("Error while marshalling trade data")
+		}
+		err = stub.PutState(tr.TradeID, b)
+		if err != nil {
+			return fail("Error while writing Trade data to ledger")
+		}
+		err = indexTrade(stub, tr)
+		if err != nil {
+			return fail(err.Error())
+		}
+		err = addToInboxes(stub, tr.TradeID, requests[i].TransactionID)
+		if err != nil {
+			return fail(err.Error())
+		}
+		err = updateTradeHistory(stub, client.EntityID, tr.TradeID)
+		if err != nil {
+			return fail("Error while updating trade history")
+		}
+		err = updateTradeState(stub, tr.TradeID, requests[i].TransactionID, "Quote requested")
+		if err != nil {
+			return fail("Error while updating trade state")
+		}
+	}
+
+	err = writeStrategy(stub, strategy)
+	if err != nil {
+		return fail(err.Error())
+	}
+	err = stub.PutState("currentStrategyNum", []byte(strconv.Itoa(strategyNum)))
+	if err != nil {
+		return fail("Error while writing currentStrategyNum to ledger")
+	}
+	err = stub.PutState("currentTransactionNum", []byte(strconv.Itoa(tid+len(legs))))
+	if err != nil {
+		return fail("Error while updating current transaction number")
+	}
+	err = stub.PutState("currentTradeNum", []byte(strconv.Itoa(tradeNum+len(legs))))
+	if err != nil {
+		return fail("Error while updating current trade number")
+	}
+	err = emitTradeEvent(stub, newTradeEvent(requests[0], "Quote requested"))
+	if err != nil {
+		return fail(err.Error())
+	}
+	return []byte(strategy.StrategyID), nil
+}
+
+// used by bank to quote all legs of a strategy, called by respondToQuote
+/*			arg 0	:	StrategyID
+			arg 1	:	Leg quotes as JSON in leg order, e.g. [{"StockRate":120,"OptionPrice":6.4},{"StockRate":130,"OptionPrice":2.1}]
+			arg 2	:	Net premium of the package, empty to price every leg, otherwise leg OptionPrices are left out,
+						sold legs are priced at model and the bought legs make up the net premium
+			arg 3	:	SettlementDate Year
+			arg 4	:	SettlementDate Month
+			arg 5	:	SettlementDate Day
+			arg 6	:	BankID
+*/
+func (t *SimpleChaincode) respondToStrategyQuote(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 7 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	ctidByte, err := stub.GetState("currentTransactionNum")
+	if err != nil {
+		return nil, errors.New("Error while getting currentTransactionNum from ledger")
+	}
+	tid, err := strconv.Atoi(string(ctidByte))
+	if err != nil {
+		return nil, errors.New("Error while converting ctidByte to integer")
+	}
+	transactionID := "trans" + strconv.Itoa(tid+1)
+	fail := func(msg string) ([]byte, error) {
+		_ = updateTransactionStatus(stub, transactionID, msg)
+		return nil, nil
+	}
+
+	strategy, err := readStrategy(stub, args[0])
+	if err != nil {
+		return fail(err.Error())
+	}
+	if strategy.Status != "Quote requested" && strategy.Status != "Responded" {
+		return fail("Error cannot respond to strategy in status " + strategy.Status)
+	}
+	bankID := args[6]
+	requests, err := strategyRequests(stub, strategy)
+	if err != nil {
+		return fail(err.Error())
+	}
+	var quotes []LegQuote
+	err = json.Unmarshal([]byte(args[1]), &quotes)
+	if err != nil || len(quotes) != len(requests) {
+		return fail("Error invalid leg quotes, one is needed for every leg")
+	}
+	year, err := strconv.Atoi(args[3])
+	if err != nil {
+		return fail("Error invalid Expiration date")
+	}
+	month, err := strconv.Atoi(args[4])
+	if err != nil {
+		return fail("Error invalid Expiration date")
+	}
+	day, err := strconv.Atoi(args[5])
+	if err != nil {
+		return fail("Error invalid Expiration date")
+	}
+	settlementDate, err := strictDate(year, month, day)
+	if err != nil {
+		return fail(err.Error())
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return fail(err.Error())
+	}
+	if settlementDate.Before(now) {
+		return fail("Error cannot respond to quote due to incorrect Expiration date")
+	}
+
+	legs := make([]Leg, len(requests))
+	for i := 0; i < len(requests); i++ {
+		legs[i] = Leg{OptionType: requests[i].OptionType, StockSymbol: requests[i].StockSymbol, Quantity: requests[i].Quantity, Side: requests[i].Side}
+		err = checkHalts(stub, requests[i].StockSymbol, strategy.ClientID, bankID)
+		if err != nil {
+			return fail(err.Error())
+		}
+		err = checkCorporateActionTerms(stub, requests[i].StockSymbol, requests[i].Timestamp)
+		if err != nil {
+			return fail(err.Error())
+		}
+		calendar, err := instrumentCalendar(stub, requests[i].StockSymbol)
+		if err != nil {
+			return fail(err.Error())
+		}
+		err = calendar.validateExpiry(settlementDate)
+		if err != nil {
+			return fail(err.Error())
+		}
+	}
+	err = validateStrategyStrikes(strategy.StrategyType, legs, quotes)
+	if err != nil {
+		return fail(err.Error())
+	}
+
+	// premium is quoted per leg or as one net premium split over the legs
+	net := 0.0
+	perLeg := args[2] == ""
+	if perLeg {
+		for i := 0; i < len(requests); i++ {
+			net += sideSign(requests[i].Side) * quotes[i].OptionPrice * float64(shares(requests[i].Quantity, requests[i].Multiplier))
+		}
+	} else {
+		net, err = strconv.ParseFloat(args[2], 64)
+		if err != nil {
+			return fail("Error invalid net premium")
+		}
+		err = allocateNetPremium(stub, requests, quotes, settlementDate, net, now)
+		if err != nil {
+			return fail(err.Error())
+		}
+	}
+	for i := 0; i < len(requests); i++ {
+		err = validateQuote(stub, requests[i].StockSymbol, requests[i].OptionType, nil, nil, quotes[i].OptionPrice, quotes[i].StockRate, settlementDate)
+		if err != nil {
+			return fail(fmt.Sprintf("Error leg %d: %s", i+1, err.Error()))
+		}
+		instrument, err := getInstrument(stub, requests[i].StockSymbol)
+		if err != nil {
+			return fail(err.Error())
+		}
+		if !onTick(instrument, quotes[i].StockRate) || (perLeg && !onTick(instrument, quotes[i].OptionPrice)) {
+			return fail("Error quote rejected: prices must be multiples of the tick size " + strconv.FormatFloat(instrument.TickSize, 'f', -1, 64))
+		}
+	}
+
+	// a bank quoting again keeps a single entry of the legs in its trade history
+	quoted := false
+	for i := 0; i < len(strategy.Quotes); i++ {
+		if strategy.Quotes[i].BankID == bankID {
+			quoted = true
+		}
+	}
+	quote := StrategyQuote{QuoteID: transactionID, BankID: bankID, NetPremium: net, Timestamp: now}
+	var first Transaction
+	for i := 0; i < len(requests); i++ {
+		r := Transaction{
+			TransactionID:   "trans" + strconv.Itoa(tid+1+i),
+			TradeID:         requests[i].TradeID,
+			StrategyID:      strategy.StrategyID,
+			TransactionType: "Response",
+			OptionType:      requests[i].OptionType,
+			Side:            requests[i].Side,
+			ClientID:        strategy.ClientID,
+			BankID:          bankID,
+			StockSymbol:     requests[i].StockSymbol,
+			Quantity:        requests[i].Quantity,
+			Multiplier:      requests[i].Multiplier,
+			OptionPrice:     quotes[i].OptionPrice,
+			StockRate:       quotes[i].StockRate,
+			SettlementDate:  settlementDate,
+			Timestamp:       quote.Timestamp,
+			ActorID:         bankID,
+			Status:          "Success",
+		}
+		if i == 0 {
+			first = r
+		}
+		err = writeTransaction(stub, r)
+		if err != nil {
+			return fail(err.Error())
+		}
+		err = putIndexEntry(stub, nil, bankQuoteIndex, bankID, r.TradeID, r.TransactionID)
+		if err != nil {
+			return fail(err.Error())
+		}
+		err = delIndexEntry(stub, inboxIndex, bankID, r.TradeID)
+		if err != nil {
+			return fail(err.Error())
+		}
+		if !quoted {
+			err = updateTradeHistory(stub, bankID, r.TradeID)
+			if err != nil {
+				return fail("Error while updating trade history")
+			}
+		}
+		err = updateTradeState(stub, r.TradeID, r.TransactionID, "Responded")
+		if err != nil {
+			return fail("Error while updating trade state")
+		}
+		quote.Responses = append(quote.Responses, r.TransactionID)
+	}
+
+	strategy.Quotes = append(strategy.Quotes, quote)
+	strategy.Status = "Responded"
+	err = writeStrategy(stub, strategy)
+	if err != nil {
+		return fail(err.Error())
+	}
+	err = stub.PutState("currentTransactionNum", []byte(strconv.Itoa(tid+len(requests))))
+	if err != nil {
+		return fail("Error while writing current Transaction Number to ledger")
+	}
+	err = emitTradeEvent(stub, newTradeEvent(first, "Responded"))
+	if err != nil {
+		return fail(err.Error())
+	}
+	return []byte(quote.QuoteID), nil
+}
+
+// used by client to execute all legs of a strategy at one bank's quote, called by tradeExec
+/*			arg 0	:	StrategyID
+			arg 1	:	QuoteID of the selected strategy quote
+			arg 2	:	ClientID
+*/
+func (t *SimpleChaincode) executeStrategy(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	ctidByte, err := stub.GetState("currentTransactionNum")
+	if err != nil {
+		return nil, errors.New("Error while getting current Transaction Number from ledger")
+	}
+	tid, err := strconv.Atoi(string(ctidByte))
+	if err != nil {
+		return nil, errors.New("Error while converting ctidByte to integer")
+	}
+	transactionID := "trans" + strconv.Itoa(tid+1)
+	fail := func(msg string) ([]byte, error) {
+		_ = updateTransactionStatus(stub, transactionID, msg)
+		return nil, nil
+	}
+
+	strategy, err := readStrategy(stub, args[0])
+	if err != nil {
+		return fail(err.Error())
+	}
+	if strategy.ClientID != args[2] {
+		return fail("Error only the requesting client can execute a strategy")
+	}
+	if strategy.Status != "Responded" {
+		return fail("Error cannot execute strategy in status " + strategy.Status)
+	}
+	var quote *StrategyQuote
+	for i := 0; i < len(strategy.Quotes); i++ {
+		if strategy.Quotes[i].QuoteID == args[1] {
+			quote = &strategy.Quotes[i]
+		}
+	}
+	if quote == nil {
+		return fail("Error quote " + args[1] + " is not a quote of strategy " + strategy.StrategyID)
+	}
+
+	// every leg is checked before anything is written
+	now, err := txTime(stub)
+	if err != nil {
+		return fail(err.Error())
+	}
+	execs := make([]Transaction, len(quote.Responses))
+	for i := 0; i < len(quote.Responses); i++ {
+		quotebyte, err := stub.GetState(quote.Responses[i])
+		if err != nil {
+			return fail("Error while getting quote data")
+		}
+		var response Transaction
+		err = json.Unmarshal(quotebyte, &response)
+		if err != nil {
+			return fail("Error while unmarshalling quote data")
+		}
+		if response.SettlementDate.Before(now) {
+			return fail("Error cannot execute trade due to invalid Expiration date")
+		}
+		err = checkHalts(stub, response.StockSymbol, strategy.ClientID, response.BankID)
+		if err != nil {
+			return fail(err.Error())
+		}
+		err = checkCorporateActionTerms(stub, response.StockSymbol, response.Timestamp)
+		if err != nil {
+			return fail(err.Error())
+		}
+		execs[i] = response
+		execs[i].TransactionID = "trans" + strconv.Itoa(tid+1+i)
+		execs[i].TransactionType = "Execute"
+		execs[i].Timestamp = now
+		execs[i].ActorID = strategy.ClientID
+	}
+	symbols, contracts, notional := exposureBySymbol(execs, func(tran Transaction) float64 { return tran.StockRate })
+	counterparties := []string{strategy.ClientID, quote.BankID}
+	for i := 0; i < len(counterparties); i++ {
+		party, err := getEntity(stub, counterparties[i])
+		if err != nil {
+			return fail(err.Error())
+		}
+		for j := 0; j < len(symbols); j++ {
+			err = checkPositionLimits(stub, party, symbols[j], contracts[symbols[j]], notional[symbols[j]], transactionID, strategy.Legs[0], "Execute")
+			if err != nil {
+				return fail(err.Error())
+			}
+		}
+	}
+
+	for i := 0; i < len(execs); i++ {
+		e := execs[i]
+		err = writeTransaction(stub, e)
+		if err != nil {
+			return fail(err.Error())
+		}
+		// both counterparties record every leg, linked by the strategy
+		owners := []string{e.ClientID, e.BankID}
+		for j := 0; j < len(owners); j++ {
+			entity, err := getEntity(stub, owners[j])
+			if err != nil {
+				return fail(err.Error())
+			}
+			counterparty := e.BankID
+			if owners[j] == e.BankID {
+				counterparty = e.ClientID
+			}
+			entity.Options = append(entity.Options, Option{Symbol: e.StockSymbol, Quantity: e.Quantity, Multiplier: e.Multiplier, OptionType: e.OptionType, StockRate: e.StockRate, SettlementDate: e.SettlementDate, OptionPrice: e.OptionPrice, EntityID: counterparty, TradeID: e.TradeID, StrategyID: e.StrategyID, Side: e.Side})
+			err = recordPositionChanges(stub, entity, e.TransactionID, e.TradeID)
+			if err != nil {
+				return fail(err.Error())
+			}
+			b, err := json.Marshal(entity)
+			if err != nil {
+				return fail("Error while marshalling entity data")
+			}
+			err = stub.PutState(entity.EntityID, b)
+			if err != nil {
+				return fail("Error while writing entity to ledger")
+			}
+		}
+		err = updateTradeState(stub, e.TradeID, e.TransactionID, "Trade Executed")
+		if err != nil {
+			return fail("Error while updating trade state")
+		}
+		err = updateTradeBank(stub, e.TradeID, e.BankID)
+		if err != nil {
+			return fail("Error while updating trade state")
+		}
+		err = removeFromInboxes(stub, e.TradeID)
+		if err != nil {
+			return fail(err.Error())
+		}
+		err = surveilExecution(stub, e)
+		if err != nil {
+			return fail(err.Error())
+		}
+	}
+
+	strategy.BankID = quote.BankID
+	strategy.Status = "Trade Executed"
+	err = writeStrategy(stub, strategy)
+	if err != nil {
+		return fail(err.Error())
+	}
+	err = stub.PutState("currentTransactionNum", []byte(strconv.Itoa(tid+len(execs))))
+	if err != nil {
+		return fail("Error while writing currentTransactionNum to ledger")
+	}
+	err = emitTradeEvent(stub, newTradeEvent(execs[0], "Trade Executed"))
+	if err != nil {
+		return fail(err.Error())
+	}
+	return nil, nil
+}
+
+// used by client to withdraw all legs of a strategy not executed yet, called by cancelQuoteRequest
+/*			arg 0	:	StrategyID
+			arg 1	:	ClientID
+*/
+func (t *SimpleChaincode) cancelStrategy(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	ctidByte, err := stub.GetState("currentTransactionNum")
+	if err != nil {
+		return nil, errors.New("Error while getting currentTransactionNum from ledger")
+	}
+	tid, err := strconv.Atoi(string(ctidByte))
+	if err != nil {
+		return nil, errors.New("Error while converting ctidByte to integer")
+	}
+	transactionID := "trans" + strconv.Itoa(tid+1)
+	fail := func(msg string) ([]byte, error) {
+		_ = updateTransactionStatus(stub, transactionID, msg)
+		return nil, nil
+	}
+
+	strategy, err := readStrategy(stub, args[0])
+	if err != nil {
+		return fail(err.Error())
+	}
+	if strategy.ClientID != args[1] {
+		return fail("Error only the requesting client can cancel a quote request")
+	}
+	if strategy.Status != "Quote requested" && strategy.Status != "Responded" {
+		return fail("Error cannot cancel quote request of a strategy in status " + strategy.Status)
+	}
+	requests, err := strategyRequests(stub, strategy)
+	if err != nil {
+		return fail(err.Error())
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return fail(err.Error())
+	}
+	var first Transaction
+	for i := 0; i < len(requests); i++ {
+		c := Transaction{
+			TransactionID:   "trans" + strconv.Itoa(tid+1+i),
+			TradeID:         requests[i].TradeID,
+			StrategyID:      strategy.StrategyID,
+			TransactionType: "Cancel",
+			OptionType:      requests[i].OptionType,
+			Side:            requests[i].Side,
+			ClientID:        strategy.ClientID,
+			StockSymbol:     requests[i].StockSymbol,
+			Quantity:        requests[i].Quantity,
+			Timestamp:       now,
+			ActorID:         strategy.ClientID,
+			Status:          "Success",
+		}
+		if i == 0 {
+			first = c
+		}
+		err = writeTransaction(stub, c)
+		if err != nil {
+			return fail(err.Error())
+		}
+		err = removeFromInboxes(stub, c.TradeID)
+		if err != nil {
+			return fail(err.Error())
+		}
+		err = surveilWithdrawal(stub, c)
+		if err != nil {
+			return fail(err.Error())
+		}
+		err = updateTradeState(stub, c.TradeID, c.TransactionID, "Quote Cancelled")
+		if err != nil {
+			return fail("Error while updating trade state")
+		}
+	}
+	strategy.Status = "Quote Cancelled"
+	err = writeStrategy(stub, strategy)
+	if err != nil {
+		return fail(err.Error())
+	}
+	err = stub.PutState("currentTransactionNum", []byte(strconv.Itoa(tid+len(requests))))
+	if err != nil {
+		return fail("Error while writing currentTransactionNum to ledger")
+	}
+	err = emitTradeEvent(stub, newTradeEvent(first, "Quote Cancelled"))
+	if err != nil {
+		return fail(err.Error())
+	}
+	return nil, nil
+}
+
+// strategy with its legs and the quotes of the banks
+/*			arg 0	:	StrategyID
+*/
+func (t *SimpleChaincode) getStrategy(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	strategy, err := readStrategy(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(strategy)
+	if err != nil {
+		return nil, errors.New("Error while marshalling strategy")
+	}
+	return b, nil
+}
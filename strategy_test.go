@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestAllocateNetPremium(t *testing.T) {
+	now := time.Date(2026, 3, 2, 15, 0, 0, 0, time.UTC)
+	settlementDate := now.AddDate(0, 0, 365)
+	stub := newTestStub(now)
+	b, _ := json.Marshal(MarketData{Symbol: "IBM", Spot: 100, Close: 100, Volatility: 0.2, Timestamp: now})
+	stub.PutState(marketDataKey("IBM"), b)
+	b, _ = json.Marshal(QuoteRule{Symbol: "IBM", Rate: 0.05, ExerciseStyle: "European"})
+	stub.PutState(quoteRuleKey("IBM"), b)
+	// call spread, bought 100 call worth 10.4506 and sold 110 call worth 6.0401
+	requests := []Transaction{
+		{StockSymbol: "IBM", OptionType: "call", Side: buySide, Quantity: 1, Multiplier: 1},
+		{StockSymbol: "IBM", OptionType: "call", Side: sellSide, Quantity: 1, Multiplier: 1},
+	}
+	tests := []struct {
+		name     string
+		requests []Transaction
+		net      float64
+		want     []float64
+	}{
+		{"net premium at the model value", requests, 4.4105, []float64{10.4506, 6.0401}},
+		{"net premium below the model value", requests, 2.20525, []float64{8.24535, 6.0401}},
+		{"zero net premium", requests, 0, []float64{6.0401, 6.0401}},
+		{"net credit on a debit spread", requests, -1, []float64{5.0401, 6.0401}},
+		{"every leg sold", []Transaction{
+			{StockSymbol: "IBM", OptionType: "call", Side: sellSide, Quantity: 1, Multiplier: 1},
+			{StockSymbol: "IBM", OptionType: "call", Side: sellSide, Quantity: 1, Multiplier: 1},
+		}, -8.24535, []float64{5.2253, 3.02005}},
+	}
+	for _, tt := range tests {
+		quotes := []LegQuote{{StockRate: 100}, {StockRate: 110}}
+		err := allocateNetPremium(stub, tt.requests, quotes, settlementDate, tt.net, now)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		for i := 0; i < len(quotes); i++ {
+			if !within(quotes[i].OptionPrice, tt.want[i], 1e-3) {
+				t.Errorf("%s: leg %d got %.4f, want %.4f", tt.name, i, quotes[i].OptionPrice, tt.want[i])
+			}
+		}
+		net := 0.0
+		for i := 0; i < len(quotes); i++ {
+			net += sideSign(tt.requests[i].Side) * quotes[i].OptionPrice
+		}
+		if !within(net, tt.net, 1e-9) {
+			t.Errorf("%s: legs add up to %.6f, want %.6f", tt.name, net, tt.net)
+		}
+	}
+	// a credit larger than the sold leg would price the bought leg below zero
+	quotes := []LegQuote{{StockRate: 100}, {StockRate: 110}}
+	err := allocateNetPremium(stub, requests, quotes, settlementDate, -7, now)
+	if err == nil {
+		t.Errorf("expected an error for a net credit above the value of the sold leg")
+	}
+	// legs priced on stale market data are rejected
+	stale := newTestStub(now.Add(2 * marketDataMaxAge))
+	stale.state = stub.state
+	err = allocateNetPremium(stale, requests, quotes, settlementDate, 4.41, stale.now)
+	if err == nil {
+		t.Errorf("expected an error for stale market data")
+	}
+}
+
+func TestSoldLegHeldByBank(t *testing.T) {
+	cc := new(SimpleChaincode)
+	stub := newTestLedger(t, time.Date(2026, 3, 2, 15, 0, 0, 0, time.UTC))
+	_, err := cc.publishMarketData(stub, []string{"AAPL", "130", "128", "0.25", entity5})
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	// call spread, the client buys the 120 call and sells the 140 call
+	legs := `[{"OptionType":"call","StockSymbol":"AAPL","Quantity":1,"Side":"Buy"},{"OptionType":"call","StockSymbol":"AAPL","Quantity":1,"Side":"Sell"}]`
+	b, err := cc.requestForQuote(stub, []string{"Spread", legs, entity1})
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	strategyID := string(b)
+	b, err = cc.respondToQuote(stub, []string{strategyID, `[{"StockRate":120},{"StockRate":140}]`, "0", "2027", "6", "17", entity2})
+	if err != nil {
+		t.Fatalf("response: %v", err)
+	}
+	executeID := nextTransactionID(stub)
+	_, err = cc.tradeExec(stub, []string{strategyID, string(b), entity1})
+	if err != nil || transactionStatus(stub, executeID) != "Success" {
+		t.Fatalf("execution: %v %s", err, transactionStatus(stub, executeID))
+	}
+	strategy, err := readStrategy(stub, strategyID)
+	if err != nil {
+		t.Fatalf("strategy: %v", err)
+	}
+	sold := strategy.Legs[1]
+	var trade Trade
+	_ = json.Unmarshal(stub.state[sold], &trade)
+	if trade.Side != sellSide {
+		t.Errorf("sold leg %s has side %q", sold, trade.Side)
+	}
+
+	// the client wrote the call, only the bank can exercise it
+	exerciseID := nextTransactionID(stub)
+	_, _ = cc.tradeSet(stub, []string{sold, "Yes", entity1})
+	if got, want := transactionStatus(stub, exerciseID), "Error "+entity1+" does not hold the option of "+sold; got != want {
+		t.Errorf("exercise by the writer: got status %q, want %q", got, want)
+	}
+	_, _ = cc.tradeSet(stub, []string{sold, "Yes", entity2})
+	if got := transactionStatus(stub, exerciseID); got != "Success" {
+		t.Fatalf("exercise by the holder: got status %q", got)
+	}
+	var exercise Transaction
+	_ = json.Unmarshal(stub.state[exerciseID], &exercise)
+	if exercise.ClientID != entity1 || exercise.BankID != entity2 || exercise.ActorID != entity2 {
+		t.Errorf("exercise %+v does not record the client, the bank and the bank exercising", exercise)
+	}
+	// the bank receives the share the client wrote the call on
+	client, _ := getEntity(stub, entity1)
+	for _, s := range client.Portfolio {
+		if s.Symbol == "AAPL" && s.Quantity != 19 {
+			t.Errorf("client holds %d AAPL after delivering, want 19", s.Quantity)
+		}
+	}
+	// the bought leg is still the client's
+	_, _ = cc.tradeSet(stub, []string{strategy.Legs[0], "Yes", entity2})
+	if got := transactionStatus(stub, nextTransactionID(stub)); got == "Success" {
+		t.Errorf("bank exercised the leg the client bought")
+	}
+}
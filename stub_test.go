@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// ledger of a single transaction stamped now, the methods not overridden are not used by the functions tested
+type testStub struct {
+	shim.ChaincodeStubInterface
+	state map[string][]byte
+	now   time.Time
+	event []byte // payload of the last event set
+}
+
+func newTestStub(now time.Time) *testStub {
+	return &testStub{state: make(map[string][]byte), now: now}
+}
+
+// ledger as Init leaves it, with the entities, instruments and calendars of a new chaincode
+func newTestLedger(t *testing.T, now time.Time) *testStub {
+	stub := newTestStub(now)
+	_, err := new(SimpleChaincode).Init(stub, "init", nil)
+	if err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	return stub
+}
+
+// status of a transaction written by an invoke, Success or the error it was rejected with
+func transactionStatus(stub *testStub, transactionID string) string {
+	var tran Transaction
+	_ = json.Unmarshal(stub.state[transactionID], &tran)
+	return tran.Status
+}
+
+// ID the next invoke writes its transaction under, whether it succeeds or not
+func nextTransactionID(stub *testStub) string {
+	num, _ := strconv.Atoi(string(stub.state["currentTransactionNum"]))
+	return "trans" + strconv.Itoa(num+1)
+}
+
+// requests, quotes and executes a call of the client with the first bank expiring on 17 June 2027,
+// with the exotic terms if any, returns the trade ID
+func executeTestCall(t *testing.T, stub *testStub, symbol string, quantity string, premium string, strike string, terms ...string) string {
+	cc := new(SimpleChaincode)
+	quoteID := nextTransactionID(stub)
+	_, _ = cc.requestForQuote(stub, append([]string{"call", symbol, quantity, entity1}, terms...))
+	var rfq Transaction
+	_ = json.Unmarshal(stub.state[quoteID], &rfq)
+	if rfq.Status != "Success" {
+		t.Fatalf("request: %s", rfq.Status)
+	}
+	responseID := nextTransactionID(stub)
+	_, _ = cc.respondToQuote(stub, []string{rfq.TradeID, quoteID, premium, strike, "2027", "6", "17", entity2})
+	if status := transactionStatus(stub, responseID); status != "Success" {
+		t.Fatalf("response: %s", status)
+	}
+	executeID := nextTransactionID(stub)
+	_, _ = cc.tradeExec(stub, []string{rfq.TradeID, responseID, entity1})
+	if status := transactionStatus(stub, executeID); status != "Success" {
+		t.Fatalf("execution: %s", status)
+	}
+	return rfq.TradeID
+}
+
+func (s *testStub) GetState(key string) ([]byte, error) {
+	return s.state[key], nil
+}
+
+func (s *testStub) PutState(key string, value []byte) error {
+	s.state[key] = value
+	return nil
+}
+
+func (s *testStub) DelState(key string) error {
+	delete(s.state, key)
+	return nil
+}
+
+// keys from startKey to endKey, both included, in order
+func (s *testStub) RangeQueryState(startKey string, endKey string) (shim.StateRangeQueryIteratorInterface, error) {
+	iter := &testIterator{state: s.state}
+	for key := range s.state {
+		if key >= startKey && key <= endKey {
+			iter.keys = append(iter.keys, key)
+		}
+	}
+	sort.Strings(iter.keys)
+	return iter, nil
+}
+
+func (s *testStub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	return &timestamp.Timestamp{Seconds: s.now.Unix(), Nanos: int32(s.now.Nanosecond())}, nil
+}
+
+func (s *testStub) SetEvent(name string, payload []byte) error {
+	s.event = payload
+	return nil
+}
+
+// self-signed certificate, the invokes take the caller from their arguments
+func (s *testStub) GetCallerCertificate() ([]byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "test"}, NotBefore: s.now, NotAfter: s.now.Add(time.Hour)}
+	return x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+}
+
+type testIterator struct {
+	keys  []string
+	state map[string][]byte
+	i     int
+}
+
+func (it *testIterator) HasNext() bool {
+	return it.i < len(it.keys)
+}
+
+func (it *testIterator) Next() (string, []byte, error) {
+	key := it.keys[it.i]
+	it.i++
+	return key, it.state[key], nil
+}
+
+func (it *testIterator) Close() error {
+	return nil
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const relatedIndex = "related"     // entityID, related entityID
+const withdrawalIndex = "withdraw" // clientID, tradeID -> time the quote request was cancelled
+const alertStatusIndex = "alert"   // status, alertID
+
+// surveillance rules
+const washTradeRule = "WashTrade"
+const offMarketRule = "OffMarketPremium"
+const quoteWithdrawRule = "QuoteAndWithdraw"
+const unusualSizeRule = "UnusualSize"
+
+// thresholds of the surveillance rules, a zero threshold disables its rule
+type SurveillanceConfig struct {
+	OffMarketPct     float64 // max distance of the premium from the model price, in percent
+	WithdrawCount    int     // cancelled quote requests of a client within the window raising an alert
+	WithdrawWindowHr int
+	SizeFactor       float64 // quantity above this multiple of the average executed quantity of the symbol
+	MinSizeSamples   int     // executed trades of the symbol needed before sizes are compared
+}
+
+var defaultSurveillanceConfig = SurveillanceConfig{
+	OffMarketPct:     25,
+	WithdrawCount:    3,
+	WithdrawWindowHr: 24,
+	SizeFactor:       5,
+	MinSizeSamples:   5,
+}
+
+// executed quantities of a symbol
+type SizeStats struct {
+	Trades    int
+	Contracts int
+}
+
+type Alert struct {
+	AlertID       string
+	Rule          string
+	TradeID       string
+	TransactionID string
+	Entities      []string
+	StockSymbol   string
+	Details       string
+	Status        string // Open, Dismissed, Escalated or Confirmed
+	CreatedAt     time.Time
+	Disposition   string // note of the regulatory body
+	DispositionBy string
+	DispositionAt time.Time
+}
+
+func surveillanceConfig(stub shim.ChaincodeStubInterface) (SurveillanceConfig, error) {
+	config := defaultSurveillanceConfig
+	b, err := stub.GetState("surveillanceConfig")
+	if err != nil {
+		return config, errors.New("Error while getting surveillance config from ledger")
+	}
+	if len(b) == 0 {
+		return config, nil
+	}
+	err = json.Unmarshal(b, &config)
+	if err != nil {
+		return config, errors.New("Error while unmarshalling surveillance config")
+	}
+	return config, nil
+}
+
+func sizeStatsKey(symbol string) string {
+	return "sizeStats_" + strings.ToUpper(symbol)
+}
+
+func relatedEntities(stub shim.ChaincodeStubInterface, a string, b string) (bool, error) {
+	if a == b {
+		return true, nil
+	}
+	value, err := stub.GetState(indexKey(relatedIndex, a, b))
+	if err != nil {
+		return false, errors.New("Error while getting related entities from ledger")
+	}
+	return len(value) != 0, nil
+}
+
+// writes a new open alert under the next alert ID
+func raiseAlert(stub shim.ChaincodeStubInterface, alert Alert) error {
+	num := 1000
+	numbyte, err := stub.GetState("currentAlertNum")
+	if err != nil {
+		return errors.New("Error while getting currentAlertNum from ledger")
+	}
+	if len(numbyte) != 0 {
+		num, err = strconv.Atoi(string(numbyte))
+		if err != nil {
+			return errors.New("Error while converting currentAlertNum to integer")
+		}
+	}
+	num++
+	alert.AlertID = "alert" + strconv.Itoa(num)
+	alert.Status = "Open"
+	alert.CreatedAt, err = txTime(stub)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(alert)
+	if err != nil {
+		return errors.New("Error while marshalling alert")
+	}
+	err = stub.PutState(alert.AlertID, b)
+	if err != nil {
+		return errors.New("Error while writing alert to ledger")
+	}
+	err = stub.PutState("currentAlertNum", []byte(strconv.Itoa(num)))
+	if err != nil {
+		return errors.New("Error while writing currentAlertNum to ledger")
+	}
+	err = putIndexEntry(stub, nil, alertStatusIndex, statusIndexValue(alert.Status), alert.AlertID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// runs the execution time rules on an executed trade and raises an alert per rule hit
+func surveilExecution(stub shim.ChaincodeStubInterface, tran Transaction) error {
+	config, err := surveillanceConfig(stub)
+	if err != nil {
+		return err
+	}
+	var alerts []Alert
+
+	related, err := relatedEntities(stub, tran.ClientID, tran.BankID)
+	if err != nil {
+		return err
+	}
+	if related {
+		alerts = append(alerts, Alert{Rule: washTradeRule, Details: "client " + tran.ClientID + " and bank " + tran.BankID + " are related"})
+	}
+
+	// forwards and swaps are quoted at a price or rate rather than a premium, held to the strike band only
+	if config.OffMarketPct > 0 && !isLinear(tran.OptionType) {
+		rule, _, err := quoteRuleFor(stub, tran.StockSymbol)
+		if err != nil {
+			return err
+		}
+		// without a fresh reference price the premium cannot be checked
+		m, err := underlyingMarketData(stub, tran.StockSymbol, tran.Basket)
+		if err == nil {
+			fair, err := termsPrice(stub, rule.ExerciseStyle, heldTerms(tran), m.Spot, m.Volatility, rule.Rate, yearFraction(tran.Timestamp, tran.SettlementDate))
+			if err == nil && fair > 0 {
+				distance := math.Abs(tran.OptionPrice-fair) / fair * 100
+				if distance > config.OffMarketPct {
+					alerts = append(alerts, Alert{Rule: offMarketRule, Details: fmt.Sprintf("option price %.2f is %.2f%% away from model price %.2f", tran.OptionPrice, distance, fair)})
+				}
+			}
+		}
+	}
+
+	var stats SizeStats
+	statsbyte, err := stub.GetState(sizeStatsKey(tran.StockSymbol))
+	if err != nil {
+		return errors.New("Error while getting size statistics from ledger")
+	}
+	if len(statsbyte) != 0 {
+		err = json.Unmarshal(statsbyte, &stats)
+		if err != nil {
+			return errors.New("Error while unmarshalling size statistics")
+		}
+	}
+	if config.SizeFactor > 0 && stats.Trades >= config.MinSizeSamples {
+		average := float64(stats.Contracts) / float64(stats.Trades)
+		if float64(tran.Quantity) > config.SizeFactor*average {
+			alerts = append(alerts, Alert{Rule: unusualSizeRule, Details: fmt.Sprintf("%d contracts against an average of %.2f", tran.Quantity, average)})
+		}
+	}
+	stats.Trades++
+	stats.Contracts += tran.Quantity
+	statsbyte, err = json.Marshal(stats)
+	if err != nil {
+		return errors.New("Error while marshalling size statistics")
+	}
+	err = stub.PutState(sizeStatsKey(tran.StockSymbol), statsbyte)
+	if err != nil {
+		return errors.New("Error while writing size statistics to ledger")
+	}
+
+	for i := 0; i < len(alerts); i++ {
+		alerts[i].TradeID = tran.TradeID
+		alerts[i].TransactionID = tran.TransactionID
+		alerts[i].Entities = []string{tran.ClientID, tran.BankID}
+		alerts[i].StockSymbol = tran.StockSymbol
+		err = raiseAlert(stub, alerts[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// records a cancelled quote request and raises an alert when the client withdraws too often
+func surveilWithdrawal(stub shim.ChaincodeStubInterface, tran Transaction) error {
+	config, err := surveillanceConfig(stub)
+	if err != nil {
+		return err
+	}
+	entries, err := scanIndex(stub, withdrawalIndex, tran.ClientID)
+	if err != nil {
+		return err
+	}
+	err = putIndexEntry(stub, []byte(tran.Timestamp.Format(time.RFC3339)), withdrawalIndex, tran.ClientID, tran.TradeID)
+	if err != nil {
+		return err
+	}
+	if config.WithdrawCount <= 0 {
+		return nil
+	}
+	since := tran.Timestamp.Add(-time.Duration(config.WithdrawWindowHr) * time.Hour)
+	count := 1
+	for i := 0; i < len(entries); i++ {
+		at, err := time.Parse(time.RFC3339, string(entries[i].Value))
+		if err == nil && at.After(since) {
+			count++
+		}
+	}
+	if count < config.WithdrawCount {
+		return nil
+	}
+	return raiseAlert(stub, Alert{
+		Rule:          quoteWithdrawRule,
+		TradeID:       tran.TradeID,
+		TransactionID: tran.TransactionID,
+		Entities:      []string{tran.ClientID},
+		StockSymbol:   tran.StockSymbol,
+		Details:       fmt.Sprintf("%d quote requests cancelled within %d hours", count, config.WithdrawWindowHr),
+	})
+}
+
+func requireRegBody(stub shim.ChaincodeStubInterface, entityID string, action string) error {
+	entity, err := getEntity(stub, entityID)
+	if err != nil {
+		return err
+	}
+	if entity.EntityType != "RegBody" {
+		return errors.New("Error only Regulatory Body can " + action)
+	}
+	return nil
+}
+
+// used by the regulatory body to mark two entities as related, or unrelated
+/*			arg 0	:	EntityID
+			arg 1	:	EntityID
+			arg 2	:	Yes/ No
+			arg 3	:	RegBody EntityID
+*/
+func (t *SimpleChaincode) setRelatedEntities(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	err := requireRegBody(stub, args[3], "relate entities")
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < 2; i++ {
+		_, err = getEntity(stub, args[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	if strings.EqualFold(args[2], "yes") {
+		err = putIndexEntry(stub, nil, relatedIndex, args[0], args[1])
+		if err == nil {
+			err = putIndexEntry(stub, nil, relatedIndex, args[1], args[0])
+		}
+	} else {
+		err = delIndexEntry(stub, relatedIndex, args[0], args[1])
+		if err == nil {
+			err = delIndexEntry(stub, relatedIndex, args[1], args[0])
+		}
+	}
+	return nil, err
+}
+
+// used by the regulatory body to tune the surveillance rules
+/*			arg 0	:	Off market premium in percent of model price (0 to disable)
+			arg 1	:	Cancelled quote requests raising an alert (0 to disable)
+			arg 2	:	Window of cancelled quote requests in hours
+			arg 3	:	Unusual size as a multiple of the average quantity (0 to disable)
+			arg 4	:	Executed trades of a symbol needed before checking size
+			arg 5	:	RegBody EntityID
+*/
+func (t *SimpleChaincode) setSurveillanceConfig(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 6 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	err := requireRegBody(stub, args[5], "configure surveillance")
+	if err != nil {
+		return nil, err
+	}
+	var config SurveillanceConfig
+	config.OffMarketPct, err = strconv.ParseFloat(args[0], 64)
+	if err != nil || config.OffMarketPct < 0 {
+		return nil, errors.New("Error invalid off market percentage")
+	}
+	config.WithdrawCount, err = strconv.Atoi(args[1])
+	if err != nil || config.WithdrawCount < 0 {
+		return nil, errors.New("Error invalid withdrawal count")
+	}
+	config.WithdrawWindowHr, err = strconv.Atoi(args[2])
+	if err != nil || config.WithdrawWindowHr <= 0 {
+		return nil, errors.New("Error invalid withdrawal window")
+	}
+	config.SizeFactor, err = strconv.ParseFloat(args[3], 64)
+	if err != nil || config.SizeFactor < 0 {
+		return nil, errors.New("Error invalid size factor")
+	}
+	config.MinSizeSamples, err = strconv.Atoi(args[4])
+	if err != nil || config.MinSizeSamples < 0 {
+		return nil, errors.New("Error invalid size samples")
+	}
+	b, err := json.Marshal(config)
+	if err != nil {
+		return nil, errors.New("Error while marshalling surveillance config")
+	}
+	err = stub.PutState("surveillanceConfig", b)
+	if err != nil {
+		return nil, errors.New("Error while writing surveillance config to ledger")
+	}
+	return nil, nil
+}
+
+// used by the regulatory body to close or escalate an alert
+/*			arg 0	:	AlertID
+			arg 1	:	Dismissed/ Escalated/ Confirmed
+			arg 2	:	Note
+			arg 3	:	RegBody EntityID
+*/
+func (t *SimpleChaincode) dispositionAlert(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	err := requireRegBody(stub, args[3], "disposition alerts")
+	if err != nil {
+		return nil, err
+	}
+	status := ""
+	for _, s := range []string{"Dismissed", "Escalated", "Confirmed"} {
+		if strings.EqualFold(args[1], s) {
+			status = s
+		}
+	}
+	if status == "" {
+		return nil, errors.New("Error invalid alert disposition " + args[1])
+	}
+	alertbyte, err := stub.GetState(args[0])
+	if err != nil {
+		return nil, errors.New("Error while getting alert from ledger")
+	}
+	if len(alertbyte) == 0 || !strings.HasPrefix(args[0], "alert") {
+		return nil, errors.New("Error alert " + args[0] + " not found")
+	}
+	var alert Alert
+	err = json.Unmarshal(alertbyte, &alert)
+	if err != nil {
+		return nil, errors.New("Error while unmarshalling alert")
+	}
+	err = delIndexEntry(stub, alertStatusIndex, statusIndexValue(alert.Status), alert.AlertID)
+	if err != nil {
+		return nil, err
+	}
+	alert.Status = status
+	alert.Disposition = args[2]
+	alert.DispositionBy = args[3]
+	alert.DispositionAt, err = txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(alert)
+	if err != nil {
+		return nil, errors.New("Error while marshalling alert")
+	}
+	err = stub.PutState(alert.AlertID, b)
+	if err != nil {
+		return nil, errors.New("Error while writing alert to ledger")
+	}
+	return nil, putIndexEntry(stub, nil, alertStatusIndex, statusIndexValue(alert.Status), alert.AlertID)
+}
+
+// alerts newest first, all of them or those in a status
+/*			arg 0	:	RegBody EntityID
+			arg 1	:	Status (optional)
+*/
+func (t *SimpleChaincode) getAlerts(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	err := requireRegBody(stub, args[0], "access alerts")
+	if err != nil {
+		return nil, err
+	}
+	var alertIDs []string
+	if len(args) == 2 {
+		entries, err := scanIndex(stub, alertStatusIndex, statusIndexValue(args[1]))
+		if err != nil {
+			return nil, err
+		}
+		for i := len(entries) - 1; i >= 0; i-- {
+			alertIDs = append(alertIDs, entries[i].Attrs[0])
+		}
+	} else {
+		numbyte, err := stub.GetState("currentAlertNum")
+		if err != nil {
+			return nil, errors.New("Error while getting currentAlertNum from ledger")
+		}
+		num, _ := strconv.Atoi(string(numbyte))
+		for ; num > 1000; num-- {
+			alertIDs = append(alertIDs, "alert"+strconv.Itoa(num))
+		}
+	}
+	alerts := make([]Alert, len(alertIDs))
+	for i := 0; i < len(alertIDs); i++ {
+		alertbyte, err := stub.GetState(alertIDs[i])
+		if err != nil {
+			return nil, errors.New("Error while getting alert from ledger")
+		}
+		err = json.Unmarshal(alertbyte, &alerts[i])
+		if err != nil {
+			return nil, errors.New("Error while unmarshalling alert")
+		}
+	}
+	b, err := json.Marshal(alerts)
+	if err != nil {
+		return nil, errors.New("Error while marshalling alerts")
+	}
+	return b, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestRelatedEntityExecutionAlert(t *testing.T) {
+	cc := new(SimpleChaincode)
+	stub := newTestLedger(t, time.Date(2026, 3, 2, 15, 0, 0, 0, time.UTC))
+	_, err := cc.publishMarketData(stub, []string{"AAPL", "130", "128", "0.25", entity5})
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	_, err = cc.setRelatedEntities(stub, []string{entity1, entity2, "Yes", entity2})
+	if err == nil {
+		t.Errorf("expected an error for entities related by a bank")
+	}
+	_, err = cc.setRelatedEntities(stub, []string{entity1, entity2, "Yes", entity4})
+	if err != nil {
+		t.Fatalf("relate entities: %v", err)
+	}
+
+	// premium at the model price, so only the related entities are flagged
+	tradeID := executeTestCall(t, stub, "AAPL", "1", "14.68", "130")
+
+	b, err := cc.getAlerts(stub, []string{entity4, "Open"})
+	if err != nil {
+		t.Fatalf("get alerts: %v", err)
+	}
+	var alerts []Alert
+	_ = json.Unmarshal(b, &alerts)
+	if len(alerts) != 1 {
+		t.Fatalf("got %d alerts, want 1: %s", len(alerts), b)
+	}
+	alert := alerts[0]
+	if alert.Rule != washTradeRule || alert.TradeID != tradeID || len(alert.Entities) != 2 || alert.Entities[0] != entity1 || alert.Entities[1] != entity2 {
+		t.Errorf("alert %+v does not flag the execution between related entities", alert)
+	}
+}
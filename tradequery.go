@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const maxTradePageSize = 100
+
+// filters of queryTrades, empty fields are not applied
+type TradeFilter struct {
+	Status       string
+	Symbol       string
+	TradeType    string // Call/ Put
+	Counterparty string // matches the client or the bank of a trade
+	FromDate     string // YYYY-MM-DD, trades requested on or after
+	ToDate       string // YYYY-MM-DD, trades requested on or before
+}
+
+type TradePage struct {
+	Trades   []Trade
+	Count    int
+	Bookmark string // TradeID to pass to get the next page, empty on the last page
+}
+
+func (f TradeFilter) matches(trade Trade, from time.Time, to time.Time) bool {
+	if f.Status != "" && !strings.EqualFold(trade.Status, f.Status) {
+		return false
+	}
+	if f.Symbol != "" && !strings.EqualFold(trade.Symbol, f.Symbol) {
+		return false
+	}
+	if f.TradeType != "" && !strings.EqualFold(trade.TradeType, f.TradeType) {
+		return false
+	}
+	if f.Counterparty != "" && trade.ClientID != f.Counterparty && trade.BankID != f.Counterparty {
+		return false
+	}
+	if !from.IsZero() && trade.CreatedAt.Before(from) {
+		return false
+	}
+	if !to.IsZero() && !trade.CreatedAt.Before(to) {
+		return false
+	}
+	return true
+}
+
+// trade IDs visible to an entity, newest first
+func visibleTradeIDs(stub shim.ChaincodeStubInterface, entity Entity) ([]string, error) {
+	var tradeIDs []string
+	if entity.EntityType == "RegBody" {
+		ctidByte, err := stub.GetState("currentTradeNum")
+		if err != nil {
+			return nil, errors.New("Error while getting currentTradeNum from ledger")
+		}
+		tradeNum, err := strconv.Atoi(string(ctidByte))
+		if err != nil {
+			return nil, errors.New("Error while converting ctidByte to integer")
+		}
+		for ; tradeNum > 1000; tradeNum-- {
+			tradeIDs = append(tradeIDs, "trade"+strconv.Itoa(tradeNum))
+		}
+		return tradeIDs, nil
+	}
+	seen := make(map[string]bool)
+	for i := len(entity.TradeHistory) - 1; i >= 0; i-- {
+		if !seen[entity.TradeHistory[i]] {
+			seen[entity.TradeHistory[i]] = true
+			tradeIDs = append(tradeIDs, entity.TradeHistory[i])
+		}
+	}
+	return tradeIDs, nil
+}
+
+// candidate trades for a filter, read from an index where the filter allows it
+func candidateTradeIDs(stub shim.ChaincodeStubInterface, entity Entity, filter TradeFilter) ([]string, error) {
+	if entity.EntityType != "RegBody" {
+		return visibleTradeIDs(stub, entity)
+	}
+	if filter.Status != "" {
+		return tradeIDsFromIndex(stub, 0, statusIndex, statusIndexValue(filter.Status))
+	}
+	if filter.Symbol != "" {
+		return tradeIDsFromIndex(stub, 0, symbolIndex, symbolIndexValue(filter.Symbol))
+	}
+	if filter.Counterparty != "" {
+		asClient, err := tradeIDsFromIndex(stub, 0, clientIndex, filter.Counterparty)
+		if err != nil {
+			return nil, err
+		}
+		asBank, err := tradeIDsFromIndex(stub, 0, bankQuoteIndex, filter.Counterparty)
+		if err != nil {
+			return nil, err
+		}
+		tradeIDs := append(asClient, asBank...)
+		sort.Sort(byTradeNumDesc(tradeIDs))
+		return tradeIDs, nil
+	}
+	return visibleTradeIDs(stub, entity)
+}
+
+// one page of the trades visible to an entity, newest first
+/*			arg 0	:	EntityID
+			arg 1	:	Page size
+			arg 2	:	Bookmark returned with the previous page, empty for the first page
+			arg 3	:	Filters as JSON, e.g. {"Status":"Trade Executed","Symbol":"AAPL","FromDate":"2017-01-01"} (optional)
+*/
+func (t *SimpleChaincode) queryTrades(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 3 && len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	pageSize, err := strconv.Atoi(args[1])
+	if err != nil || pageSize < 1 {
+		return nil, errors.New("Error invalid page size")
+	}
+	if pageSize > maxTradePageSize {
+		pageSize = maxTradePageSize
+	}
+	var filter TradeFilter
+	if len(args) == 4 && args[3] != "" {
+		err = json.Unmarshal([]byte(args[3]), &filter)
+		if err != nil {
+			return nil, errors.New("Error while unmarshalling trade filter")
+		}
+	}
+	var from, to time.Time
+	if filter.FromDate != "" {
+		from, err = time.Parse("2006-01-02", filter.FromDate)
+		if err != nil {
+			return nil, errors.New("Error invalid from date")
+		}
+	}
+	if filter.ToDate != "" {
+		to, err = time.Parse("2006-01-02", filter.ToDate)
+		if err != nil {
+			return nil, errors.New("Error invalid to date")
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+
+	tradeIDs, err := candidateTradeIDs(stub, entity, filter)
+	if err != nil {
+		return nil, err
+	}
+	start := 0
+	if args[2] != "" {
+		start = -1
+		for i := 0; i < len(tradeIDs); i++ {
+			if tradeIDs[i] == args[2] {
+				start = i
+				break
+			}
+		}
+		if start < 0 {
+			return nil, errors.New("Error invalid bookmark " + args[2])
+		}
+	}
+
+	page := TradePage{Trades: []Trade{}}
+	i := start
+	for ; i < len(tradeIDs) && len(page.Trades) < pageSize; i++ {
+		tradebyte, err := stub.GetState(tradeIDs[i])
+		if err != nil {
+			return nil, errors.New("Error while getting trades info from ledger")
+		}
+		var trade Trade
+		err = json.Unmarshal(tradebyte, &trade)
+		if err != nil {
+			return nil, errors.New("Error while unmarshalling trades")
+		}
+		if filter.matches(trade, from, to) {
+			page.Trades = append(page.Trades, trade)
+		}
+	}
+	if i < len(tradeIDs) {
+		page.Bookmark = tradeIDs[i]
+	}
+	page.Count = len(page.Trades)
+	b, err := json.Marshal(page)
+	if err != nil {
+		return nil, errors.New("Error while marshalling trades")
+	}
+	return b, nil
+}
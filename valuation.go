@@ -0,0 +1,361 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+type StockMark struct {
+	Symbol   string
+	Quantity int
+	Price    float64
+	Value    float64
+}
+
+type OptionMark struct {
+	TradeID        string
+	Symbol         string
+	OptionType     string
+	Quantity       int // signed contracts, positive for long and negative for short positions
+	StockRate      float64
+	SettlementDate time.Time
+	OptionPrice    float64 // premium per share agreed at execution
+	Price          float64 // model price per share
+	Value          float64
+	UnrealizedPnL  float64
+}
+
+type Valuation struct {
+	EntityID       string
+	Timestamp      time.Time
+	Rate           float64
+	Stocks         []StockMark
+	Options        []OptionMark
+	PortfolioValue float64
+	OptionsValue   float64
+	TotalValue     float64
+	RealizedPnL    float64 // premiums, exercise and settlement value of closed trades and swap resets
+	UnrealizedPnL  float64 // open options marked against their premium
+}
+
+type PnLSnapshot struct {
+	EntityID       string
+	Date           string // YYYY-MM-DD
+	Timestamp      time.Time
+	PortfolioValue float64
+	OptionsValue   float64
+	TotalValue     float64
+	RealizedPnL    float64
+	UnrealizedPnL  float64
+	DailyPnL       float64 // change in realized plus unrealized P&L since the previous snapshot
+}
+
+func pnlSnapshotKey(entityID string, date string) string {
+	return "pnl_" + entityID + "_" + date
+}
+
+func pnlDatesKey(entityID string) string {
+	return "pnlDates_" + entityID
+}
+
+// marks the portfolio and open options of an entity to market using published prices
+func valueEntity(stub shim.ChaincodeStubInterface, entity Entity, rate float64) (Valuation, error) {
+	now, err := txTime(stub)
+	if err != nil {
+		return Valuation{}, err
+	}
+	v := Valuation{EntityID: entity.EntityID, Timestamp: now, Rate: rate}
+	market := make(map[string]MarketData)
+	marketFor := func(symbol string) (MarketData, error) {
+		m, ok := market[symbol]
+		if ok {
+			return m, nil
+		}
+		m, err := getFreshMarketData(stub, symbol)
+		if err != nil {
+			return m, err
+		}
+		market[symbol] = m
+		return m, nil
+	}
+
+	for i := 0; i < len(entity.Portfolio); i++ {
+		s := entity.Portfolio[i]
+		if s.Quantity == 0 {
+			continue
+		}
+		m, err := marketFor(s.Symbol)
+		if err != nil {
+			return v, err
+		}
+		mark := StockMark{Symbol: s.Symbol, Quantity: s.Quantity, Price: m.Spot, Value: m.Spot * float64(s.Quantity)}
+		v.Stocks = append(v.Stocks, mark)
+		v.PortfolioValue += mark.Value
+	}
+
+	for i := 0; i < len(entity.Options); i++ {
+		o := entity.Options[i]
+		var m MarketData
+		var err error
+		if o.Basket != nil {
+			// basket names are chosen per trade, never cached
+			m, err = underlyingMarketData(stub, o.Symbol, o.Basket)
+		} else {
+			m, err = marketFor(o.Symbol)
+		}
+		if err != nil {
+			return v, err
+		}
+		price, err := termsPrice(stub, "", o, m.Spot, m.Volatility, rate, yearFraction(now, o.SettlementDate))
+		if err != nil {
+			return v, err
+		}
+		qty := optionSign(entity, o) * o.Quantity
+		mark := OptionMark{
+			TradeID:        o.TradeID,
+			Symbol:         o.Symbol,
+			OptionType:     o.OptionType,
+			Quantity:       qty,
+			StockRate:      o.StockRate,
+			SettlementDate: o.SettlementDate,
+			OptionPrice:    o.OptionPrice,
+			Price:          price,
+			Value:          price * float64(shares(qty, o.Multiplier)),
+			UnrealizedPnL:  (price - o.OptionPrice) * float64(shares(qty, o.Multiplier)),
+		}
+		v.Options = append(v.Options, mark)
+		v.OptionsValue += mark.Value
+		v.UnrealizedPnL += mark.UnrealizedPnL
+	}
+	v.TotalValue = v.PortfolioValue + v.OptionsValue
+
+	realized, err := realizedPnL(stub, entity)
+	if err != nil {
+		return v, err
+	}
+	v.RealizedPnL = realized
+	return v, nil
+}
+
+// P&L of the entity's closed trades, the premium plus the intrinsic value at exercise or the settlement of
+// forwards and swaps
+func realizedPnL(stub shim.ChaincodeStubInterface, entity Entity) (float64, error) {
+	pnl := 0.0
+	// a trade appears in the history once per quote or leg recorded for the entity, count it once
+	seen := make(map[string]bool)
+	for i := 0; i < len(entity.TradeHistory); i++ {
+		if seen[entity.TradeHistory[i]] {
+			continue
+		}
+		seen[entity.TradeHistory[i]] = true
+		tradebyte, err := stub.GetState(entity.TradeHistory[i])
+		if err != nil {
+			return 0, errors.New("Error while getting trade info from ledger")
+		}
+		var trade Trade
+		err = json.Unmarshal(tradebyte, &trade)
+		if err != nil {
+			return 0, errors.New("Error while unmarshalling trade data")
+		}
+		// open swaps have realized the cash of their resets
+		openSwap := trade.Status == "Trade Executed" && trade.TradeType == swapProduct
+		if trade.Status != "Trade Exercised" && trade.Status != "Trade Expired" && trade.Status != "Trade Cancelled" && trade.Status != "Trade Knocked Out" && trade.Status != "Trade Settled" && !openSwap {
+			continue
+		}
+		var exec, exercise, closing, settle *Transaction
+		resets := 0.0
+		for j := 0; j < len(trade.TransactionHistory); j++ {
+			if trade.TransactionHistory[j] == "" {
+				continue
+			}
+			tranbyte, err := stub.GetState(trade.TransactionHistory[j])
+			if err != nil {
+				return 0, errors.New("Error while getting transaction from ledger")
+			}
+			var tran Transaction
+			err = json.Unmarshal(tranbyte, &tran)
+			if err != nil {
+				return 0, errors.New("Error while unmarshalling transaction data")
+			}
+			if tran.TransactionType == "Execute" {
+				exec = &tran
+			} else if tran.TransactionType == "Exercise" {
+				exercise = &tran
+			} else if tran.TransactionType == "Expire" || tran.TransactionType == "KnockOut" {
+				closing = &tran
+			} else if tran.TransactionType == "Settle" {
+				settle = &tran
+			} else if tran.TransactionType == "Reset" {
+				resets += tran.CashSettlement
+			}
+		}
+		// only the executing client and bank hold a position in the trade
+		if exec == nil || (exec.ClientID != entity.EntityID && exec.BankID != entity.EntityID) {
+			continue
+		}
+		// the exercise carries the terms in force, which a corporate action may have adjusted since execution
+		payoff := 0.0
+		if exercise != nil && cashSettled(*exercise) {
+			payoff = exercise.CashSettlement
+		} else if exercise != nil && exercise.SpotPrice > 0 {
+			payoff = intrinsicValue(exercise.OptionType, exercise.SpotPrice, exercise.StockRate) * float64(shares(exercise.Quantity, exercise.Multiplier))
+		}
+		// forwards and swaps pay at settlement, swaps also on every reset
+		if settle != nil {
+			payoff = linearPayoff(*settle)
+		}
+		payoff += resets
+		// barrier rebate paid on knock-out or at expiry of a knock-in never knocked in
+		if closing != nil {
+			payoff += barrierRebate(*closing) * float64(shares(closing.Quantity, closing.Multiplier))
+		}
+		sign := float64(optionSign(entity, Option{Side: exec.Side}))
+		pnl += sign * (payoff - exec.OptionPrice*float64(shares(exec.Quantity, exec.Multiplier)))
+	}
+	return pnl, nil
+}
+
+/*			arg 0	:	EntityID
+			arg 1	:	Risk free rate
+*/
+func (t *SimpleChaincode) getValuation(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	rate, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return nil, errors.New("Error invalid risk free rate")
+	}
+	v, err := valueEntity(stub, entity, rate)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.New("Error while marshalling valuation")
+	}
+	return b, nil
+}
+
+// stores the day's valuation and P&L of an entity, a later snapshot on the same day replaces it
+/*			arg 0	:	EntityID
+			arg 1	:	Risk free rate
+*/
+func (t *SimpleChaincode) snapshotPnL(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	rate, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return nil, errors.New("Error invalid risk free rate")
+	}
+	v, err := valueEntity(stub, entity, rate)
+	if err != nil {
+		return nil, err
+	}
+
+	var dates []string
+	datesbyte, err := stub.GetState(pnlDatesKey(entity.EntityID))
+	if err != nil {
+		return nil, errors.New("Error while getting P&L dates from ledger")
+	}
+	if len(datesbyte) != 0 {
+		err = json.Unmarshal(datesbyte, &dates)
+		if err != nil {
+			return nil, errors.New("Error while unmarshalling P&L dates")
+		}
+	}
+	snap := PnLSnapshot{
+		EntityID:       entity.EntityID,
+		Date:           v.Timestamp.UTC().Format("2006-01-02"),
+		Timestamp:      v.Timestamp,
+		PortfolioValue: v.PortfolioValue,
+		OptionsValue:   v.OptionsValue,
+		TotalValue:     v.TotalValue,
+		RealizedPnL:    v.RealizedPnL,
+		UnrealizedPnL:  v.UnrealizedPnL,
+	}
+	if len(dates) > 0 && dates[len(dates)-1] == snap.Date {
+		dates = dates[:len(dates)-1]
+	}
+	if len(dates) > 0 {
+		prevbyte, err := stub.GetState(pnlSnapshotKey(entity.EntityID, dates[len(dates)-1]))
+		if err != nil {
+			return nil, errors.New("Error while getting previous P&L snapshot from ledger")
+		}
+		var prev PnLSnapshot
+		err = json.Unmarshal(prevbyte, &prev)
+		if err != nil {
+			return nil, errors.New("Error while unmarshalling previous P&L snapshot")
+		}
+		snap.DailyPnL = (snap.RealizedPnL + snap.UnrealizedPnL) - (prev.RealizedPnL + prev.UnrealizedPnL)
+	} else {
+		snap.DailyPnL = snap.RealizedPnL + snap.UnrealizedPnL
+	}
+	dates = append(dates, snap.Date)
+
+	b, err := json.Marshal(snap)
+	if err != nil {
+		return nil, errors.New("Error while marshalling P&L snapshot")
+	}
+	err = stub.PutState(pnlSnapshotKey(entity.EntityID, snap.Date), b)
+	if err != nil {
+		return nil, errors.New("Error while writing P&L snapshot to ledger")
+	}
+	b, err = json.Marshal(dates)
+	if err != nil {
+		return nil, errors.New("Error while marshalling P&L dates")
+	}
+	err = stub.PutState(pnlDatesKey(entity.EntityID), b)
+	if err != nil {
+		return nil, errors.New("Error while writing P&L dates to ledger")
+	}
+	return nil, nil
+}
+
+/*			arg 0	:	EntityID
+*/
+func (t *SimpleChaincode) getPnLHistory(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments")
+	}
+	var dates []string
+	datesbyte, err := stub.GetState(pnlDatesKey(args[0]))
+	if err != nil {
+		return nil, errors.New("Error while getting P&L dates from ledger")
+	}
+	if len(datesbyte) != 0 {
+		err = json.Unmarshal(datesbyte, &dates)
+		if err != nil {
+			return nil, errors.New("Error while unmarshalling P&L dates")
+		}
+	}
+	snaps := make([]PnLSnapshot, len(dates))
+	for i := 0; i < len(dates); i++ {
+		snapbyte, err := stub.GetState(pnlSnapshotKey(args[0], dates[i]))
+		if err != nil {
+			return nil, errors.New("Error while getting P&L snapshot from ledger")
+		}
+		err = json.Unmarshal(snapbyte, &snaps[i])
+		if err != nil {
+			return nil, errors.New("Error while unmarshalling P&L snapshot")
+		}
+	}
+	b, err := json.Marshal(snaps)
+	if err != nil {
+		return nil, errors.New("Error while marshalling P&L history")
+	}
+	return b, nil
+}